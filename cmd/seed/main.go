@@ -1,7 +1,10 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"os"
+	"rotate-token-demo/internal/clock"
 	"rotate-token-demo/internal/models"
 	"rotate-token-demo/internal/storage"
 	"time"
@@ -11,11 +14,12 @@ import (
 )
 
 func main() {
-	userStorage := storage.NewInMemoryUserStorage()
+	userStorage := storage.NewInMemoryUserStorage(clock.System)
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
 	if err != nil {
-		log.Fatal("Failed to hash password:", err)
+		slog.Error("Failed to hash password", "error", err)
+		os.Exit(1)
 	}
 
 	demoUser := &models.User{
@@ -26,7 +30,8 @@ func main() {
 		CreateAt: time.Now(),
 	}
 
-	if err := userStorage.CreateUser(demoUser); err != nil {
-		log.Fatal("Failed to create demo user:", err)
+	if err := userStorage.CreateUser(context.Background(), demoUser); err != nil {
+		slog.Error("Failed to create demo user", "error", err)
+		os.Exit(1)
 	}
 }
@@ -11,6 +11,10 @@ import type {
   RefreshRequest 
 } from '../types';
 
+// One ID per user action: a request retried after a token refresh keeps its ID,
+// and the refresh itself is sent with it too.
+const REQUEST_ID_HEADER = 'X-Request-ID';
+
 class ApiClient {
   private client: AxiosInstance;
   private refreshTokenPromise: Promise<TokenPair> | null = null;
@@ -33,6 +37,9 @@ class ApiClient {
         if (tokens?.access_token) {
           config.headers.Authorization = `Bearer ${tokens.access_token}`;
         }
+        if (!config.headers[REQUEST_ID_HEADER]) {
+          config.headers[REQUEST_ID_HEADER] = crypto.randomUUID();
+        }
         return config;
       },
       (error) => Promise.reject(error)
@@ -46,7 +53,7 @@ class ApiClient {
           originalRequest._retry = true;
 
           try {
-            await this.handleTokenRefresh();
+            await this.handleTokenRefresh(originalRequest.headers[REQUEST_ID_HEADER]);
             const tokens = this.getStoredTokens();
             if (tokens?.access_token) {
               originalRequest.headers.Authorization = `Bearer ${tokens.access_token}`;
@@ -74,7 +81,7 @@ class ApiClient {
     );
   }
 
-  private async handleTokenRefresh(): Promise<TokenPair> {
+  private async handleTokenRefresh(requestId?: string): Promise<TokenPair> {
     if (this.refreshTokenPromise) {
       return this.refreshTokenPromise;
     }
@@ -84,7 +91,7 @@ class ApiClient {
       throw new Error('No refresh token available');
     }
 
-    this.refreshTokenPromise = this.refreshTokens({ refresh_token: tokens.refresh_token });
+    this.refreshTokenPromise = this.refreshTokens({ refresh_token: tokens.refresh_token }, requestId);
     
     try {
       const newTokens = await this.refreshTokenPromise;
@@ -157,8 +164,9 @@ class ApiClient {
     return tokens;
   }
 
-  async refreshTokens(refreshData: RefreshRequest): Promise<TokenPair> {
-    const response: AxiosResponse<APIResponse<TokenPair>> = await this.client.post('/auth/refresh', refreshData);
+  async refreshTokens(refreshData: RefreshRequest, requestId?: string): Promise<TokenPair> {
+    const headers = requestId ? { [REQUEST_ID_HEADER]: requestId } : undefined;
+    const response: AxiosResponse<APIResponse<TokenPair>> = await this.client.post('/auth/refresh', refreshData, { headers });
     
     if (!response.data.success) {
       throw new Error(response.data.error || 'Token refresh failed');
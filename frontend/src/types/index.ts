@@ -49,6 +49,7 @@ export interface APIResponse<T = any> {
   message: string;
   data?: T;
   error?: string;
+  request_id?: string;
 }
 
 export interface LoginRequest {
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChangePassword and DeleteAccount are mounted behind RequireApproval, so by the
+// time they run the user has confirmed the action on their trusted device.
+
+func (h *Handlers) ChangePassword(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	if err := h.authService.ChangePassword(c.Request.Context(), userID, req.CurrentPassword, req.NewPassword, clientInfo(c)); err != nil {
+		if err == service.ErrInvalidCredentials {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success:   false,
+				Error:     "Current password is incorrect",
+				RequestID: requestID(c),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success:   false,
+			Error:     "Failed to change password: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Password changed; all sessions have been signed out",
+	})
+}
+
+func (h *Handlers) DeleteAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.authService.DeleteAccount(c.Request.Context(), userID, clientInfo(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success:   false,
+			Error:     "Failed to delete account: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Account deleted",
+	})
+}
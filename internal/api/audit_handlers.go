@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"rotate-token-demo/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxAuditQueryLimit = 1000
+
+// GetAuditLog lists audit events, newest first:
+//
+//	GET /api/v1/admin/audit?user_id=...&request_id=...&type=login&from=RFC3339&to=RFC3339&limit=100
+func (h *Handlers) GetAuditLog(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid filter: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	events, err := h.authService.AuditLog(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success:   false,
+			Error:     "Failed to query audit log: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+	if events == nil {
+		events = []*models.AuditEvent{}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Audit events retrieved successfully",
+		Data:    events,
+	})
+}
+
+func parseAuditFilter(c *gin.Context) (models.AuditFilter, error) {
+	filter := models.AuditFilter{
+		ActorID:   c.Query("user_id"),
+		RequestID: c.Query("request_id"),
+		Type:      c.Query("type"),
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return filter, err
+		}
+		filter.From = t
+	}
+
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return filter, err
+		}
+		filter.To = t
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return filter, err
+		}
+		if n > maxAuditQueryLimit {
+			n = maxAuditQueryLimit
+		}
+		filter.Limit = n
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+	"rotate-token-demo/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReloadConfig re-reads the configuration, like SIGHUP does, and reports which
+// settings were applied and which only take effect after a restart:
+//
+//	POST /api/v1/admin/config/reload
+func (h *Handlers) ReloadConfig(c *gin.Context) {
+	result, err := h.authService.ReloadConfig(c.Request.Context(), c.GetString("user_id"), clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid configuration: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Configuration reloaded",
+		Data:    result,
+	})
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// verifyDPoP checks the DPoP header of the request, if present, and returns the
+// thumbprint of the proven key. Requests without a DPoP header return an empty
+// thumbprint so that plain bearer clients keep working.
+func verifyDPoP(c *gin.Context, authService *service.AuthService, accessToken string) (string, error) {
+	proofs := c.Request.Header.Values("DPoP")
+	if len(proofs) == 0 {
+		return "", nil
+	}
+
+	c.Header("DPoP-Nonce", authService.DPoPNonce())
+
+	if len(proofs) > 1 {
+		return "", service.ErrInvalidDPoPProof
+	}
+
+	return authService.VerifyDPoPProof(c.Request.Context(), proofs[0], c.Request.Method, requestURL(c), accessToken)
+}
+
+// requestURL reconstructs the URL the client called, for comparison with the htu
+// claim. X-Forwarded-Proto is honored only from a trusted proxy.
+func requestURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" && c.GetBool("trusted_proxy") {
+		scheme = proto
+	}
+
+	return scheme + "://" + c.Request.Host + c.Request.URL.Path
+}
+
+func dpopErrorResponse(c *gin.Context, err error) {
+	message := "Invalid DPoP proof"
+	if err == service.ErrUseDPoPNonce {
+		message = "DPoP proof must include the server-provided nonce"
+	}
+
+	c.JSON(http.StatusBadRequest, models.APIResponse{
+		Success:   false,
+		Error:     message,
+		RequestID: requestID(c),
+	})
+}
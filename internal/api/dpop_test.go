@@ -0,0 +1,51 @@
+package api
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"rotate-token-demo/internal/config"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestURLTrustsForwardedProtoOnlyFromProxies(t *testing.T) {
+	cfg := config.New()
+	cfg.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.1"}
+
+	router := gin.New()
+	router.Use(TrustedProxyMiddleware(cfg.TrustedProxyNets()))
+	router.GET("/resource", func(c *gin.Context) { c.String(http.StatusOK, requestURL(c)) })
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		forwarded  string
+		want       string
+	}{
+		{"direct client claiming https", "203.0.113.9:5000", false, "https", "http://example.com/resource"},
+		{"direct client claiming http over tls", "203.0.113.9:5000", true, "http", "https://example.com/resource"},
+		{"proxy network", "10.1.2.3:5000", false, "https", "https://example.com/resource"},
+		{"proxy address", "192.0.2.1:5000", false, "https", "https://example.com/resource"},
+		{"proxy without header", "10.1.2.3:5000", false, "", "http://example.com/resource"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/resource", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-Proto", tc.forwarded)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if got := w.Body.String(); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
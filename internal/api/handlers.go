@@ -2,9 +2,12 @@ package api
 
 import (
 	"net/http"
+	"rotate-token-demo/internal/buildinfo"
+	"rotate-token-demo/internal/clock"
 	"rotate-token-demo/internal/models"
 	"rotate-token-demo/internal/service"
 	"rotate-token-demo/internal/storage"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,12 +15,17 @@ import (
 type Handlers struct {
 	authService *service.AuthService
 	qrService   *service.QRCodeService
+	clock       clock.Clock
+	// draining is closed when the server starts shutting down.
+	draining <-chan struct{}
 }
 
-func NewHandlers(authService *service.AuthService, qrService *service.QRCodeService) *Handlers {
+func NewHandlers(authService *service.AuthService, qrService *service.QRCodeService, clock clock.Clock, draining <-chan struct{}) *Handlers {
 	return &Handlers{
 		authService: authService,
 		qrService:   qrService,
+		clock:       clock,
+		draining:    draining,
 	}
 }
 
@@ -25,24 +33,27 @@ func (h *Handlers) Register(c *gin.Context) {
 	var req models.RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request data: " + err.Error(),
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
 
-	user, err := h.authService.Register(&req)
+	user, err := h.authService.Register(c.Request.Context(), &req, clientInfo(c))
 	if err != nil {
 		if err == service.ErrUserExists {
 			c.JSON(http.StatusConflict, models.APIResponse{
-				Success: false,
-				Error:   "User already exists",
+				Success:   false,
+				Error:     "User already exists",
+				RequestID: requestID(c),
 			})
 			return
 		}
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to create user: " + err.Error(),
+			Success:   false,
+			Error:     "Failed to create user: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -65,24 +76,41 @@ func (h *Handlers) Login(c *gin.Context) {
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request data: " + err.Error(),
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
 
-	tokenPair, err := h.authService.Login(&req)
+	dpopJKT, err := verifyDPoP(c, h.authService, "")
+	if err != nil {
+		dpopErrorResponse(c, err)
+		return
+	}
+
+	tokenPair, err := h.authService.Login(c.Request.Context(), &req, clientInfo(c), dpopJKT)
 	if err != nil {
 		if err == service.ErrInvalidCredentials {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Error:   "Invalid username or password",
+				Success:   false,
+				Error:     "Invalid username or password",
+				RequestID: requestID(c),
+			})
+			return
+		}
+		if err == service.ErrInvalidScope {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success:   false,
+				Error:     "Requested scope is not allowed",
+				RequestID: requestID(c),
 			})
 			return
 		}
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Login failed: " + err.Error(),
+			Success:   false,
+			Error:     "Login failed: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -98,17 +126,33 @@ func (h *Handlers) RefreshToken(c *gin.Context) {
 	var req models.RefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request data: " + err.Error(),
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
 
-	tokenPair, err := h.authService.RefreshToken(&req)
+	dpopJKT, err := verifyDPoP(c, h.authService, "")
+	if err != nil {
+		dpopErrorResponse(c, err)
+		return
+	}
+
+	tokenPair, err := h.authService.RefreshToken(c.Request.Context(), &req, clientInfo(c), dpopJKT)
 	if err != nil {
+		if err == service.ErrInvalidScope {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success:   false,
+				Error:     "Requested scope exceeds the scope originally granted",
+				RequestID: requestID(c),
+			})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, models.APIResponse{
-			Success: false,
-			Error:   "Token refresh failed: " + err.Error(),
+			Success:   false,
+			Error:     "Token refresh failed: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -124,16 +168,18 @@ func (h *Handlers) Logout(c *gin.Context) {
 	userID := c.GetString("user_id")
 	if userID == "" {
 		c.JSON(http.StatusUnauthorized, models.APIResponse{
-			Success: false,
-			Error:   "User not authenticated",
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
 		})
 		return
 	}
 
-	if err := h.authService.Logout(userID); err != nil {
+	if err := h.authService.Logout(c.Request.Context(), userID, clientInfo(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Logout failed: " + err.Error(),
+			Success:   false,
+			Error:     "Logout failed: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -148,17 +194,19 @@ func (h *Handlers) GetProfile(c *gin.Context) {
 	userID := c.GetString("user_id")
 	if userID == "" {
 		c.JSON(http.StatusUnauthorized, models.APIResponse{
-			Success: false,
-			Error:   "User not authenticated",
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
 		})
 		return
 	}
 
-	profile, err := h.authService.GetUserProfile(userID)
+	profile, err := h.authService.GetUserProfile(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to get profile: " + err.Error(),
+			Success:   false,
+			Error:     "Failed to get profile: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -171,18 +219,19 @@ func (h *Handlers) GetProfile(c *gin.Context) {
 }
 
 func (h *Handlers) GetTokenInfo(c *gin.Context) {
-	accessToken := c.GetHeader("Authorization")
-	if accessToken != "" && len(accessToken) > 7 {
-		accessToken = accessToken[7:]
+	accessToken := ""
+	if parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2); len(parts) == 2 {
+		accessToken = parts[1]
 	}
 
 	refreshToken := c.Query("refresh_token")
 
-	tokenInfo, err := h.authService.GetTokenInfo(accessToken, refreshToken)
+	tokenInfo, err := h.authService.GetTokenInfo(c.Request.Context(), accessToken, refreshToken)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to get token info: " + err.Error(),
+			Success:   false,
+			Error:     "Failed to get token info: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -200,7 +249,7 @@ func (h *Handlers) HealthCheck(c *gin.Context) {
 		Message: "API is healthy",
 		Data: gin.H{
 			"status":  "healthy",
-			"version": "1.0.0",
+			"version": buildinfo.Version,
 			"service": "rotate-token-demo",
 		},
 	})
@@ -229,8 +278,9 @@ func (h *Handlers) SimulateTokenTheft(c *gin.Context) {
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request data: " + err.Error(),
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -241,11 +291,12 @@ func (h *Handlers) SimulateTokenTheft(c *gin.Context) {
 	// - Engelli/şüpheli IP’lerden gelen refresh denemeleri
 	// - Güvenlik analizlerinde olağandışı kalıpların görülmesi
 
-	err := h.authService.RevokeTokenFamily(req.RefreshToken)
+	err := h.authService.RevokeTokenFamily(c.Request.Context(), req.RefreshToken, clientInfo(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to revoke token family: " + err.Error(),
+			Success:   false,
+			Error:     "Failed to revoke token family: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -265,17 +316,19 @@ func (h *Handlers) GetTokenStatus(c *gin.Context) {
 	refreshToken := c.Query("refresh_token")
 	if refreshToken == "" {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "refresh_token parameter is required",
+			Success:   false,
+			Error:     "refresh_token parameter is required",
+			RequestID: requestID(c),
 		})
 		return
 	}
 
-	status, err := h.authService.GetTokenStatus(refreshToken)
+	status, err := h.authService.GetTokenStatus(c.Request.Context(), refreshToken)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to get token status: " + err.Error(),
+			Success:   false,
+			Error:     "Failed to get token status: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -291,17 +344,54 @@ func (h *Handlers) GenerateQRCode(c *gin.Context) {
 	userID := c.GetString("user_id")
 	if userID == "" {
 		c.JSON(http.StatusUnauthorized, models.APIResponse{
-			Success: false,
-			Error:   "User not authenticated",
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
 		})
 		return
 	}
 
-	qrResponse, err := h.qrService.GenerateQRCode(userID)
+	var req models.QRCodeGenerateRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success:   false,
+				Error:     "Invalid request data: " + err.Error(),
+				RequestID: requestID(c),
+			})
+			return
+		}
+	}
+
+	qrResponse, err := h.qrService.GenerateQRCode(c.Request.Context(), userID, &req, clientInfo(c))
 	if err != nil {
+		switch err {
+		case service.ErrInvalidQRCodeTTL:
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success:   false,
+				Error:     "ttl_seconds is outside the allowed range",
+				RequestID: requestID(c),
+			})
+			return
+		case service.ErrInvalidQRBinding:
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success:   false,
+				Error:     "Invalid binding policy",
+				RequestID: requestID(c),
+			})
+			return
+		case service.ErrTooManyQRCodes:
+			c.JSON(http.StatusTooManyRequests, models.APIResponse{
+				Success:   false,
+				Error:     "Too many active QR codes; revoke or use one first",
+				RequestID: requestID(c),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to generate QR code: " + err.Error(),
+			Success:   false,
+			Error:     "Failed to generate QR code: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -313,67 +403,187 @@ func (h *Handlers) GenerateQRCode(c *gin.Context) {
 	})
 }
 
+func (h *Handlers) ListQRCodes(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	qrCodes, err := h.qrService.ListUserQRCodes(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success:   false,
+			Error:     "Failed to list QR codes: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "QR codes retrieved successfully",
+		Data:    qrCodes,
+	})
+}
+
+func (h *Handlers) RevokeQRCode(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	if err := h.qrService.RevokeQRCode(c.Request.Context(), userID, c.Param("id"), clientInfo(c)); err != nil {
+		if err == storage.ErrQRCodeNotFound {
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success:   false,
+				Error:     "QR code not found",
+				RequestID: requestID(c),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success:   false,
+			Error:     "Failed to revoke QR code: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "QR code revoked",
+	})
+}
+
 func (h *Handlers) ValidateQRCode(c *gin.Context) {
 	var req models.QRCodeValidationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Error:   "Invalid request data: " + err.Error(),
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
 
-	clientIP := c.ClientIP()
+	dpopJKT, err := verifyDPoP(c, h.authService, "")
+	if err != nil {
+		dpopErrorResponse(c, err)
+		return
+	}
 
-	tokenPair, err := h.qrService.ValidateQRCode(req.QRData, clientIP)
+	tokenPair, err := h.qrService.ValidateQRCode(c.Request.Context(), req.QRData, clientInfo(c), req.Scope, dpopJKT)
 	if err != nil {
-		var statusCode int
-		var errorMsg string
+		qrValidationErrorResponse(c, err)
+		return
+	}
 
-		switch err {
-		case storage.ErrQRCodeNotFound:
-			statusCode = http.StatusNotFound
-			errorMsg = "QR code not found"
-		case storage.ErrQRCodeExpired:
-			statusCode = http.StatusGone
-			errorMsg = "QR code has expired"
-		case storage.ErrQRCodeUsed:
-			statusCode = http.StatusConflict
-			errorMsg = "QR code has already been used"
-		default:
-			statusCode = http.StatusBadRequest
-			errorMsg = "QR code validation failed: " + err.Error()
-		}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "QR code validated successfully",
+		Data:    tokenPair,
+	})
+}
 
-		c.JSON(statusCode, models.APIResponse{
-			Success: false,
-			Error:   errorMsg,
+// RedeemUserCode signs in with the typed fallback code of a login QR code.
+func (h *Handlers) RedeemUserCode(c *gin.Context) {
+	var req models.QRUserCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
 
+	dpopJKT, err := verifyDPoP(c, h.authService, "")
+	if err != nil {
+		dpopErrorResponse(c, err)
+		return
+	}
+
+	tokenPair, err := h.qrService.RedeemUserCode(c.Request.Context(), &req, clientInfo(c), dpopJKT)
+	if err != nil {
+		qrValidationErrorResponse(c, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
-		Message: "QR code validated successfully",
+		Message: "User code redeemed successfully",
 		Data:    tokenPair,
 	})
 }
 
+func qrValidationErrorResponse(c *gin.Context, err error) {
+	var statusCode int
+	var errorMsg string
+
+	switch err {
+	case storage.ErrQRCodeNotFound:
+		statusCode = http.StatusNotFound
+		errorMsg = "QR code not found"
+	case storage.ErrQRCodeExpired:
+		statusCode = http.StatusGone
+		errorMsg = "QR code has expired"
+	case storage.ErrQRCodeUsed:
+		statusCode = http.StatusConflict
+		errorMsg = "QR code has already been used"
+	case service.ErrInvalidScope:
+		statusCode = http.StatusBadRequest
+		errorMsg = "Requested scope is not allowed"
+	case service.ErrQRCodeValidationFailed:
+		statusCode = http.StatusBadRequest
+		errorMsg = "Invalid QR code"
+	case service.ErrInvalidUserCode:
+		statusCode = http.StatusNotFound
+		errorMsg = "Invalid or expired user code"
+	case service.ErrUserCodeLocked:
+		statusCode = http.StatusTooManyRequests
+		errorMsg = "Too many failed attempts; try again later"
+	case service.ErrQRBindingViolation:
+		statusCode = http.StatusForbidden
+		errorMsg = "QR code cannot be redeemed from this device or network"
+	default:
+		statusCode = http.StatusBadRequest
+		errorMsg = "QR code validation failed: " + err.Error()
+	}
+
+	c.JSON(statusCode, models.APIResponse{
+		Success:   false,
+		Error:     errorMsg,
+		RequestID: requestID(c),
+	})
+}
+
 func (h *Handlers) GetDatabaseView(c *gin.Context) {
 	userID := c.GetString("user_id")
 	if userID == "" {
 		c.JSON(http.StatusUnauthorized, models.APIResponse{
-			Success: false,
-			Error:   "Authentication required",
+			Success:   false,
+			Error:     "Authentication required",
+			RequestID: requestID(c),
 		})
 		return
 	}
 
-	databaseView, err := h.qrService.GetDatabaseView()
+	databaseView, err := h.qrService.GetDatabaseView(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Error:   "Failed to get database view: " + err.Error(),
+			Success:   false,
+			Error:     "Failed to get database view: " + err.Error(),
+			RequestID: requestID(c),
 		})
 		return
 	}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"rotate-token-demo/internal/buildinfo"
+	"rotate-token-demo/internal/health"
+	"rotate-token-demo/internal/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LivenessHandler answers /livez. It only shows that the process serves
+// requests; dependencies are the readiness probe's concern, so a failing
+// backend never gets the container restarted.
+func LivenessHandler() gin.HandlerFunc {
+	startedAt := time.Now()
+
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, models.APIResponse{
+			Success: true,
+			Message: "Service is alive",
+			Data: gin.H{
+				"status": health.StatusOK,
+				"uptime": time.Since(startedAt).Round(time.Second).String(),
+				"build":  buildinfo.Get(),
+			},
+		})
+	}
+}
+
+// ReadinessHandler answers /readyz with the result of every check, and 503 if
+// any of them failed.
+func ReadinessHandler(checker *health.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := checker.Run(c.Request.Context())
+		data := gin.H{
+			"status": report.Status,
+			"checks": report.Checks,
+			"build":  buildinfo.Get(),
+		}
+
+		c.Header("Cache-Control", "no-store")
+		if report.Status != health.StatusOK {
+			c.JSON(http.StatusServiceUnavailable, models.APIResponse{
+				Success:   false,
+				Message:   "Service is not ready",
+				Data:      data,
+				Error:     "One or more readiness checks failed",
+				RequestID: requestID(c),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.APIResponse{
+			Success: true,
+			Message: "Service is ready",
+			Data:    data,
+		})
+	}
+}
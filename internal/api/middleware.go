@@ -2,101 +2,316 @@ package api
 
 import (
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"rotate-token-demo/internal/logging"
 	"rotate-token-demo/internal/models"
 	"rotate-token-demo/internal/service"
+	"runtime/debug"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
-func AuthMiddleware(authService *service.AuthService) gin.HandlerFunc {
+type authOptions struct {
+	audience string
+}
+
+// AuthOption customizes AuthMiddleware for a route group.
+type AuthOption func(*authOptions)
+
+// WithAudience only admits tokens whose aud claim contains audience.
+func WithAudience(audience string) AuthOption {
+	return func(o *authOptions) {
+		o.audience = audience
+	}
+}
+
+func AuthMiddleware(authService *service.AuthService, opts ...AuthOption) gin.HandlerFunc {
+	options := &authOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Error:   "Authorization header required",
+				Success:   false,
+				Error:     "Authorization header required",
+				RequestID: requestID(c),
 			})
 			c.Abort()
 			return
 		}
 
 		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "DPoP") {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Error:   "Invalid authorization header format",
+				Success:   false,
+				Error:     "Invalid authorization header format",
+				RequestID: requestID(c),
 			})
 			c.Abort()
 			return
 		}
 
-		claims, err := authService.ValidateAccessToken(parts[1])
+		claims, err := authService.ValidateAccessToken(c.Request.Context(), parts[1])
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Error:   "Invalid or expired token",
+				Success:   false,
+				Error:     "Invalid or expired token",
+				RequestID: requestID(c),
 			})
 			c.Abort()
 			return
 		}
 
+		// DPoP'ye bağlı token'lar Bearer olarak kullanılamaz; her istekte anahtar kanıtı istenmeli
+		if parts[0] == "DPoP" || (claims.Cnf != nil && claims.Cnf.JKT != "") {
+			if err := checkDPoPBinding(c, authService, parts[0], parts[1], claims); err != nil {
+				c.Header("WWW-Authenticate", `DPoP error="`+dpopErrorCode(err)+`", algs="ES256 RS256 PS256 EdDSA"`)
+				c.JSON(http.StatusUnauthorized, models.APIResponse{
+					Success:   false,
+					Error:     "DPoP verification failed: " + err.Error(),
+					RequestID: requestID(c),
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		if options.audience != "" && !containsString(claims.Audience, options.audience) {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{
+				Success:   false,
+				Error:     "Token is not intended for this audience",
+				RequestID: requestID(c),
+			})
+			c.Abort()
+			return
+		}
+
+		logging.Identify(c.Request.Context(), claims.UserID, claims.SessionID)
+
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("email", claims.Email)
+		c.Set("scope", claims.Scope)
+		c.Set("session_id", claims.SessionID)
 		c.Set("claims", claims)
 
 		c.Next()
 	}
 }
 
-func CORSMiddleware() gin.HandlerFunc {
+// RequireScope must run after AuthMiddleware; it rejects tokens that were not granted every listed scope.
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get("claims")
+		claims, ok := value.(*models.Claims)
+		if !ok || !service.HasScope(claims.Scope, scopes...) {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success:   false,
+				Error:     "Insufficient scope: requires " + strings.Join(scopes, " "),
+				RequestID: requestID(c),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireApproval guards a sensitive action behind a single-use approval token
+// sent in X-Approval-Token. The approval is checked before the handler runs but
+// only used up when the handler succeeds, so a rejected request (a mistyped
+// current password, say) can be retried without a new approval.
+func RequireApproval(qrService *service.QRCodeService, action string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		origin := c.GetHeader("Origin")
+		userID := c.GetString("user_id")
+		approvalToken := c.GetHeader("X-Approval-Token")
 
-		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
+		var approvalID string
+		var err error
+		if approvalToken != "" {
+			approvalID, err = qrService.VerifyApprovalToken(c.Request.Context(), approvalToken, userID, action, clientInfo(c))
+		}
+		if approvalToken == "" || err != nil {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success:   false,
+				Error:     "Approval required: confirm " + action + " on your trusted device",
+				RequestID: requestID(c),
+			})
+			c.Abort()
+			return
+		}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		c.Next()
+
+		if status := c.Writer.Status(); status < 200 || status >= 300 {
 			return
 		}
+		if err := qrService.ConsumeApproval(c.Request.Context(), approvalID, userID, action, clientInfo(c)); err != nil {
+			slog.WarnContext(c.Request.Context(), "failed to consume approval after the action succeeded", "action", action, "error", err)
+		}
+	}
+}
+
+func checkDPoPBinding(c *gin.Context, authService *service.AuthService, scheme, token string, claims *models.Claims) error {
+	if scheme != "DPoP" || claims.Cnf == nil {
+		return service.ErrInvalidDPoPProof
+	}
+
+	jkt, err := verifyDPoP(c, authService, token)
+	if err != nil {
+		return err
+	}
+	if jkt == "" {
+		return service.ErrInvalidDPoPProof
+	}
+
+	return authService.CheckTokenBinding(claims, jkt)
+}
+
+func dpopErrorCode(err error) string {
+	if err == service.ErrUseDPoPNonce {
+		return "use_dpop_nonce"
+	}
+	return "invalid_dpop_proof"
+}
+
+func clientInfo(c *gin.Context) models.ClientInfo {
+	return models.ClientInfo{
+		IPAddress:         c.ClientIP(),
+		UserAgent:         c.Request.UserAgent(),
+		DeviceFingerprint: c.GetHeader("X-Device-Fingerprint"),
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestIDHeader carries the ID that ties together the logs, audit events and
+// error responses of one request.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware adopts the caller's X-Request-ID, or generates one when it
+// is missing or unusable, stores it in the request context and echoes it in the
+// response. Clients that reuse one ID for a retried request and the token
+// refresh in between get a single ID across the whole exchange.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+
+		c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), id))
+		c.Header(RequestIDHeader, id)
+
+		c.Next()
+	}
+}
+
+// validRequestID only admits IDs that are safe to echo in headers and log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
 
+func requestID(c *gin.Context) string {
+	return logging.RequestID(c.Request.Context())
+}
+
+// TrustedProxyMiddleware marks requests that arrive from one of the configured
+// reverse proxies, so that their X-Forwarded-Proto header can be believed.
+// Anyone else could set it to make http and https URLs interchangeable.
+func TrustedProxyMiddleware(trustedProxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ip := net.ParseIP(c.RemoteIP()); ip != nil {
+			for _, proxy := range trustedProxies {
+				if proxy.Contains(ip) {
+					c.Set("trusted_proxy", true)
+					break
+				}
+			}
+		}
 		c.Next()
 	}
 }
 
+// LoggingMiddleware writes one access log record per request. It logs the path
+// without the query string, which may carry credentials, and never any headers.
 func LoggingMiddleware() gin.HandlerFunc {
-	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		return fmt.Sprintf("%s - [%s] \"%s %s %s %d %s \"%s\" %s\"\n",
-			param.ClientIP,
-			param.TimeStamp.Format(time.RFC1123),
-			param.Method,
-			param.Path,
-			param.Request.Proto,
-			param.StatusCode,
-			param.Latency,
-			param.Request.UserAgent(),
-			param.ErrorMessage,
-		)
-	})
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+		slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
 }
 
+// ErrorHandlerMiddleware turns panics into 500 responses. The panic and its stack
+// go to the structured log; gin's own recovery output would dump the request.
 func ErrorHandlerMiddleware() gin.HandlerFunc {
-	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered interface{}) {
+		slog.ErrorContext(c.Request.Context(), "panic while handling request",
+			"panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+
 		if err, ok := recovered.(string); ok {
 			c.JSON(http.StatusInternalServerError, models.APIResponse{
-				Success: false,
-				Error:   "Internal server error: " + err,
+				Success:   false,
+				Error:     "Internal server error: " + err,
+				RequestID: requestID(c),
 			})
 		} else {
 			c.JSON(http.StatusInternalServerError, models.APIResponse{
-				Success: false,
-				Error:   "Internal server error",
+				Success:   false,
+				Error:     "Internal server error",
+				RequestID: requestID(c),
 			})
 		}
 	})
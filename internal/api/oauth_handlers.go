@@ -0,0 +1,242 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	grantTypeDeviceCode    = "urn:ietf:params:oauth:grant-type:device_code"
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// OAuth endpoints respond with the plain RFC 6749 JSON bodies instead of APIResponse,
+// so that standard OAuth client libraries can talk to them.
+
+func (h *Handlers) DeviceAuthorization(c *gin.Context) {
+	var req models.DeviceAuthorizationRequest
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	resp, err := h.qrService.StartDeviceAuthorization(c.Request.Context(), &req, clientInfo(c))
+	if err != nil {
+		if err == service.ErrInvalidScope {
+			oauthError(c, http.StatusBadRequest, "invalid_scope", "")
+			return
+		}
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) OAuthToken(c *gin.Context) {
+	var req models.OAuthTokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	dpopJKT, err := verifyDPoP(c, h.authService, "")
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, err.Error(), "")
+		return
+	}
+
+	switch req.GrantType {
+	case grantTypeDeviceCode:
+		h.deviceCodeGrant(c, &req, dpopJKT)
+	case grantTypeTokenExchange:
+		h.tokenExchangeGrant(c, &req, dpopJKT)
+	default:
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+func (h *Handlers) deviceCodeGrant(c *gin.Context, req *models.OAuthTokenRequest, dpopJKT string) {
+	if req.DeviceCode == "" || req.ClientID == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "device_code and client_id are required")
+		return
+	}
+
+	tokenPair, err := h.qrService.PollDeviceToken(c.Request.Context(), string(req.DeviceCode), req.ClientID, clientInfo(c), dpopJKT)
+	if err != nil {
+		switch err {
+		case service.ErrAuthorizationPending, service.ErrSlowDown, service.ErrAccessDenied,
+			service.ErrExpiredDeviceCode, service.ErrInvalidGrant:
+			oauthError(c, http.StatusBadRequest, err.Error(), "")
+		default:
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		}
+		return
+	}
+
+	h.writeOAuthToken(c, tokenPair)
+}
+
+func (h *Handlers) tokenExchangeGrant(c *gin.Context, req *models.OAuthTokenRequest, dpopJKT string) {
+	basicAuth, err := clientCredentials(c, req)
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if req.SubjectToken == "" || req.SubjectTokenType == "" || req.ClientID == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "subject_token, subject_token_type and client_id are required")
+		return
+	}
+	if req.ActorToken != "" && req.ActorTokenType == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "actor_token_type is required with actor_token")
+		return
+	}
+
+	resp, err := h.authService.ExchangeToken(c.Request.Context(), req, clientInfo(c), dpopJKT)
+	if err != nil {
+		switch err {
+		case service.ErrInvalidScope:
+			oauthError(c, http.StatusBadRequest, "invalid_scope", "")
+		case service.ErrInvalidTarget:
+			oauthError(c, http.StatusBadRequest, "invalid_target", "audience is missing or not allowed")
+		case service.ErrInvalidClient:
+			if basicAuth {
+				c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+			}
+			oauthError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		case service.ErrUnauthorizedClient:
+			oauthError(c, http.StatusBadRequest, "unauthorized_client", "client may not request tokens for this audience")
+		case service.ErrUnsupportedTokenType:
+			oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		case service.ErrTokenInvalid:
+			// RFC 8693 §2.2.2: an invalid or expired subject or actor token is invalid_grant.
+			oauthError(c, http.StatusBadRequest, "invalid_grant", "subject or actor token is invalid or expired")
+		case service.ErrDPoPKeyMismatch:
+			oauthError(c, http.StatusBadRequest, "invalid_dpop_proof", err.Error())
+		default:
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		}
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handlers) VerifyDeviceCode(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	var req models.DeviceVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	qrCode, err := h.qrService.VerifyDeviceCode(c.Request.Context(), userID, req.UserCode, req.Approve, clientInfo(c))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidUserCode:
+			c.JSON(http.StatusNotFound, models.APIResponse{
+				Success:   false,
+				Error:     "Invalid or expired user code",
+				RequestID: requestID(c),
+			})
+			return
+		case service.ErrUserCodeLocked:
+			c.JSON(http.StatusTooManyRequests, models.APIResponse{
+				Success:   false,
+				Error:     "Too many failed attempts; try again later",
+				RequestID: requestID(c),
+			})
+			return
+		case service.ErrInvalidScope:
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success:   false,
+				Error:     "Your account may not grant the requested scope",
+				RequestID: requestID(c),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success:   false,
+			Error:     "Device verification failed: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	message := "Device authorization denied"
+	if req.Approve {
+		message = "Device authorization approved"
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: message,
+		Data: gin.H{
+			"client_id": qrCode.ClientID,
+			"status":    qrCode.Status,
+		},
+	})
+}
+
+func (h *Handlers) writeOAuthToken(c *gin.Context, tokenPair *models.TokenPair) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, models.OAuthTokenResponse{
+		AccessToken:  tokenPair.AccessToken,
+		TokenType:    tokenPair.TokenType,
+		ExpiresIn:    tokenPair.ExpiresAt - h.clock.Now().Unix(),
+		RefreshToken: tokenPair.RefreshToken,
+		Scope:        tokenPair.Scope,
+	})
+}
+
+// clientCredentials reads client_secret_basic credentials into req, which
+// otherwise keeps any client_secret_post ones, and reports whether the Basic
+// scheme was used. RFC 6749 §2.3.1 form-encodes the id and secret inside it.
+func clientCredentials(c *gin.Context, req *models.OAuthTokenRequest) (bool, error) {
+	id, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		return false, nil
+	}
+	id, idErr := url.QueryUnescape(id)
+	secret, secretErr := url.QueryUnescape(secret)
+	if idErr != nil || secretErr != nil {
+		return true, errors.New("malformed client credentials")
+	}
+	if req.ClientSecret != "" || (req.ClientID != "" && req.ClientID != id) {
+		return true, errors.New("client credentials must be sent by one method only")
+	}
+	req.ClientID = id
+	req.ClientSecret = secret
+	return true, nil
+}
+
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, models.OAuthError{
+		Error:            code,
+		ErrorDescription: description,
+		RequestID:        requestID(c),
+	})
+}
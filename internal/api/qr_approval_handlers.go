@@ -0,0 +1,185 @@
+package api
+
+import (
+	"net/http"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/service"
+	"rotate-token-demo/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handlers) RequestApproval(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	var req models.ApprovalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	resp, err := h.qrService.RequestApproval(c.Request.Context(), userID, c.GetString("session_id"), &req, clientInfo(c))
+	if err != nil {
+		approvalErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Scan this QR code with your trusted device to approve",
+		Data:    resp,
+	})
+}
+
+func (h *Handlers) ScanApproval(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	var req models.ApprovalScanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	details, err := h.qrService.ScanApproval(c.Request.Context(), userID, c.GetString("session_id"), req.QRData)
+	if err != nil {
+		approvalErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Approve or deny this action",
+		Data:    details,
+	})
+}
+
+func (h *Handlers) DecideApproval(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	var req models.ApprovalDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	details, err := h.qrService.DecideApproval(c.Request.Context(), userID, c.GetString("session_id"), req.QRData, req.Approve, clientInfo(c))
+	if err != nil {
+		approvalErrorResponse(c, err)
+		return
+	}
+
+	message := "Action denied"
+	if req.Approve {
+		message = "Action approved"
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: message,
+		Data:    details,
+	})
+}
+
+func (h *Handlers) ClaimApprovalToken(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	resp, status, err := h.qrService.ClaimApprovalToken(c.Request.Context(), userID, c.Param("id"), qrPollToken(c))
+	if err != nil {
+		if err == service.ErrApprovalPending {
+			c.JSON(http.StatusAccepted, models.APIResponse{
+				Success: false,
+				Message: "Waiting for approval",
+				Data:    gin.H{"status": status},
+			})
+			return
+		}
+		approvalErrorResponse(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Action approved",
+		Data:    resp,
+	})
+}
+
+func approvalErrorResponse(c *gin.Context, err error) {
+	var statusCode int
+	var errorMsg string
+
+	switch err {
+	case storage.ErrQRCodeNotFound:
+		statusCode = http.StatusNotFound
+		errorMsg = "Approval not found"
+	case storage.ErrQRCodeExpired:
+		statusCode = http.StatusGone
+		errorMsg = "Approval has expired"
+	case storage.ErrQRCodeUsed:
+		statusCode = http.StatusConflict
+		errorMsg = "Approval has already been used"
+	case service.ErrQRCodeValidationFailed, service.ErrUnknownApprovalAction:
+		statusCode = http.StatusBadRequest
+		errorMsg = err.Error()
+	case service.ErrApprovalDenied, service.ErrApprovalWrongUser, service.ErrApprovalNoSession, service.ErrApprovalSameSession:
+		statusCode = http.StatusForbidden
+		errorMsg = err.Error()
+	case service.ErrApprovalNotScannable:
+		statusCode = http.StatusConflict
+		errorMsg = err.Error()
+	default:
+		statusCode = http.StatusInternalServerError
+		errorMsg = "Approval failed: " + err.Error()
+	}
+
+	c.JSON(statusCode, models.APIResponse{
+		Success:   false,
+		Error:     errorMsg,
+		RequestID: requestID(c),
+	})
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"rotate-token-demo/internal/models"
+	"testing"
+)
+
+func TestApprovalRequiresSecondSessionAndPollToken(t *testing.T) {
+	s := newTestServer(t)
+
+	s.do(t, http.MethodPost, "/api/v1/auth/register", "", models.RegisterRequest{
+		Username: "alice",
+		Email:    "alice@example.com",
+		Password: "password123",
+	})
+	_, webToken := s.login(t, "alice", "password123", "")
+	_, phoneToken := s.login(t, "alice", "password123", "")
+	if webToken == "" || phoneToken == "" {
+		t.Fatal("login failed")
+	}
+
+	code, resp := s.do(t, http.MethodPost, "/api/v1/qr/approvals", webToken, models.ApprovalRequest{Action: "change_password"})
+	if code != http.StatusOK {
+		t.Fatalf("request approval: got status %d", code)
+	}
+	data := resp.Data.(map[string]interface{})
+	id, qrData, pollToken := data["id"].(string), data["qr_data"].(string), data["poll_token"].(string)
+
+	scan := models.ApprovalScanRequest{QRData: qrData}
+	if code, _ := s.do(t, http.MethodPost, "/api/v1/qr/approvals/scan", webToken, scan); code != http.StatusForbidden {
+		t.Errorf("scan from requesting session: got status %d, want %d", code, http.StatusForbidden)
+	}
+	if code, _ := s.do(t, http.MethodPost, "/api/v1/qr/approvals/scan", phoneToken, scan); code != http.StatusOK {
+		t.Fatalf("scan from second session: got status %d", code)
+	}
+
+	decide := models.ApprovalDecisionRequest{QRData: qrData, Approve: true}
+	if code, _ := s.do(t, http.MethodPost, "/api/v1/qr/approvals/decide", webToken, decide); code != http.StatusForbidden {
+		t.Errorf("decide from requesting session: got status %d, want %d", code, http.StatusForbidden)
+	}
+	if code, _ := s.do(t, http.MethodPost, "/api/v1/qr/approvals/decide", phoneToken, decide); code != http.StatusOK {
+		t.Fatalf("decide from second session: got status %d", code)
+	}
+
+	claimPath := "/api/v1/qr/approvals/" + id + "/token"
+	if code, _ := s.do(t, http.MethodPost, claimPath, webToken, nil); code != http.StatusNotFound {
+		t.Errorf("claim without poll token: got status %d, want %d", code, http.StatusNotFound)
+	}
+	code, resp = s.doWithHeader(t, http.MethodPost, claimPath, webToken, http.Header{"X-Poll-Token": {pollToken}}, nil)
+	if code != http.StatusOK {
+		t.Fatalf("claim with poll token: got status %d", code)
+	}
+	approvalToken := resp.Data.(map[string]interface{})["approval_token"].(string)
+
+	code, _ = s.doWithHeader(t, http.MethodPost, "/api/v1/account/password", webToken,
+		http.Header{"X-Approval-Token": {approvalToken}},
+		models.ChangePasswordRequest{CurrentPassword: "wrong-password", NewPassword: "password456"})
+	if code != http.StatusForbidden {
+		t.Errorf("change password with wrong current password: got status %d, want %d", code, http.StatusForbidden)
+	}
+
+	// The failed attempt did not use the approval up.
+	changePassword := func(current string) int {
+		code, _ := s.doWithHeader(t, http.MethodPost, "/api/v1/account/password", webToken,
+			http.Header{"X-Approval-Token": {approvalToken}},
+			models.ChangePasswordRequest{CurrentPassword: current, NewPassword: "password456"})
+		return code
+	}
+	if code := changePassword("password123"); code != http.StatusOK {
+		t.Fatalf("change password after a failed attempt: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := changePassword("password456"); code != http.StatusForbidden {
+		t.Errorf("reusing a consumed approval: got status %d, want %d", code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/service"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sseHeartbeatInterval = 15 * time.Second
+	longPollDefaultWait  = 25 * time.Second
+	longPollMaxWait      = 60 * time.Second
+	// streamWriteMargin is added to the expected time until the next write when
+	// extending the server's write deadline for streaming responses.
+	streamWriteMargin = 10 * time.Second
+)
+
+// QRCodeEvents streams a QR code's state transitions as Server-Sent Events until a
+// terminal state is reached, the client disconnects or the server shuts down.
+// EventSource clients reconnect on their own after a shutdown.
+func (h *Handlers) QRCodeEvents(c *gin.Context) {
+	watch, err := h.qrService.WatchQRCode(c.Request.Context(), c.Param("id"), qrPollToken(c))
+	if err != nil {
+		qrLoginErrorResponse(c, err)
+		return
+	}
+	defer watch.Cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent("status", watch.Current)
+	c.Writer.Flush()
+	if service.IsTerminalQRCodeStatus(watch.Current.Status) {
+		return
+	}
+
+	expiry := time.NewTimer(watch.ExpiresAt.Sub(h.clock.Now()))
+	defer expiry.Stop()
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		extendWriteDeadline(c, sseHeartbeatInterval+streamWriteMargin)
+
+		select {
+		case event := <-watch.Events:
+			c.SSEvent("status", event)
+			return !service.IsTerminalQRCodeStatus(event.Status)
+		case <-expiry.C:
+			c.SSEvent("status", models.QRCodeEvent{
+				QRCodeID:  watch.Current.QRCodeID,
+				Status:    models.QRCodeStatusExpired,
+				Timestamp: h.clock.Now(),
+			})
+			return false
+		case <-heartbeat.C:
+			io.WriteString(w, ": keepalive\n\n")
+			return true
+		case <-h.draining:
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// QRCodeStatus is the long-poll fallback for clients without EventSource. It
+// answers immediately when the status differs from ?since=, and otherwise waits up
+// to ?timeout= seconds for the next transition. A shutdown answers a waiting poll
+// with the current status.
+func (h *Handlers) QRCodeStatus(c *gin.Context) {
+	watch, err := h.qrService.WatchQRCode(c.Request.Context(), c.Param("id"), qrPollToken(c))
+	if err != nil {
+		qrLoginErrorResponse(c, err)
+		return
+	}
+	defer watch.Cancel()
+
+	current := watch.Current
+	since := c.Query("since")
+
+	if since == current.Status && !service.IsTerminalQRCodeStatus(current.Status) {
+		wait := longPollDefaultWait
+		if seconds, err := strconv.Atoi(c.Query("timeout")); err == nil && seconds >= 0 {
+			wait = time.Duration(seconds) * time.Second
+		}
+		if wait > longPollMaxWait {
+			wait = longPollMaxWait
+		}
+		if untilExpiry := watch.ExpiresAt.Sub(h.clock.Now()); untilExpiry < wait {
+			wait = untilExpiry
+		}
+
+		extendWriteDeadline(c, wait+streamWriteMargin)
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case event := <-watch.Events:
+			current = event
+		case <-timer.C:
+			if now := h.clock.Now(); now.After(watch.ExpiresAt) {
+				current = models.QRCodeEvent{
+					QRCodeID:  current.QRCodeID,
+					Status:    models.QRCodeStatusExpired,
+					Timestamp: now,
+				}
+			}
+		case <-h.draining:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "QR code status retrieved",
+		Data:    current,
+	})
+}
+
+// extendWriteDeadline lets a response that waits on purpose outlive the server's
+// write timeout, by d from now.
+func extendWriteDeadline(c *gin.Context, d time.Duration) {
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(d))
+}
+
+// qrPollToken reads the poll token from the query string as well as a header,
+// since browsers' EventSource cannot set request headers.
+func qrPollToken(c *gin.Context) string {
+	if token := c.GetHeader("X-Poll-Token"); token != "" {
+		return token
+	}
+	return c.Query("poll_token")
+}
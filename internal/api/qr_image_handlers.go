@@ -0,0 +1,123 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/qrimage"
+	"rotate-token-demo/internal/service"
+	"rotate-token-demo/internal/storage"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QRCodeImage renders the caller's active QR code as PNG or SVG:
+//
+//	GET /api/v1/qr/:id/image?format=png|svg&size=256&ecc=L|M|Q|H&quiet_zone=4&logo=true
+func (h *Handlers) QRCodeImage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	opts, err := parseQRImageOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid image options: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+	withLogo := c.Query("logo") == "true"
+
+	data, qrCode, err := h.qrService.RenderQRCodeImage(c.Request.Context(), userID, c.Param("id"), opts, withLogo)
+	if err != nil {
+		var statusCode int
+		var errorMsg string
+
+		switch err {
+		case storage.ErrQRCodeNotFound:
+			statusCode = http.StatusNotFound
+			errorMsg = "QR code not found"
+		case storage.ErrQRCodeExpired:
+			statusCode = http.StatusGone
+			errorMsg = "QR code has expired"
+		case storage.ErrQRCodeUsed:
+			statusCode = http.StatusGone
+			errorMsg = "QR code has already been used"
+		case service.ErrQRLogoUnavailable:
+			statusCode = http.StatusBadRequest
+			errorMsg = "No logo is configured for QR images"
+		default:
+			statusCode = http.StatusInternalServerError
+			errorMsg = "Failed to render QR code: " + err.Error()
+		}
+
+		c.JSON(statusCode, models.APIResponse{
+			Success:   false,
+			Error:     errorMsg,
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	// The image never changes for a given code and options, so it may be cached
+	// privately until the code expires.
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	maxAge := int(qrCode.ExpiresAt.Sub(h.clock.Now()).Seconds())
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+	c.Header("Expires", qrCode.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Header("Vary", "Authorization")
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	if match := c.GetHeader("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, opts.ContentType(), data)
+}
+
+func parseQRImageOptions(c *gin.Context) (qrimage.Options, error) {
+	opts := qrimage.Options{
+		Format:    strings.ToLower(c.DefaultQuery("format", qrimage.FormatPNG)),
+		Size:      qrimage.DefaultSize,
+		QuietZone: qrimage.DefaultQuietZone,
+	}
+
+	if size := c.Query("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return opts, qrimage.ErrInvalidSize
+		}
+		opts.Size = n
+	}
+
+	if quietZone := c.Query("quiet_zone"); quietZone != "" {
+		n, err := strconv.Atoi(quietZone)
+		if err != nil {
+			return opts, qrimage.ErrInvalidQuietZone
+		}
+		opts.QuietZone = n
+	}
+
+	level, err := qrimage.ParseLevel(c.Query("ecc"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Level = level
+
+	return opts, opts.Validate()
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"net/http"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/service"
+	"rotate-token-demo/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handlers) StartQRLogin(c *gin.Context) {
+	var req models.QRLoginStartRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success:   false,
+				Error:     "Invalid request data: " + err.Error(),
+				RequestID: requestID(c),
+			})
+			return
+		}
+	}
+
+	resp, err := h.qrService.StartQRLogin(c.Request.Context(), &req, clientInfo(c))
+	if err != nil {
+		if err == service.ErrInvalidScope {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success:   false,
+				Error:     "Requested scope is not allowed",
+				RequestID: requestID(c),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success:   false,
+			Error:     "Failed to start QR login: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Scan this QR code with a signed-in device",
+		Data:    resp,
+	})
+}
+
+func (h *Handlers) ScanQRLogin(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	var req models.QRLoginScanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	device, err := h.qrService.ScanQRLogin(c.Request.Context(), userID, req.QRData)
+	if err != nil {
+		qrLoginErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Approve or deny this sign-in request",
+		Data:    device,
+	})
+}
+
+func (h *Handlers) DecideQRLogin(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success:   false,
+			Error:     "User not authenticated",
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	var req models.QRLoginDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	device, err := h.qrService.DecideQRLogin(c.Request.Context(), userID, req.QRData, req.Approve, clientInfo(c))
+	if err != nil {
+		qrLoginErrorResponse(c, err)
+		return
+	}
+
+	message := "Sign-in request denied"
+	if req.Approve {
+		message = "Sign-in request approved"
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: message,
+		Data:    device,
+	})
+}
+
+func (h *Handlers) ClaimQRLogin(c *gin.Context) {
+	var req models.QRLoginTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success:   false,
+			Error:     "Invalid request data: " + err.Error(),
+			RequestID: requestID(c),
+		})
+		return
+	}
+
+	dpopJKT, err := verifyDPoP(c, h.authService, "")
+	if err != nil {
+		dpopErrorResponse(c, err)
+		return
+	}
+
+	tokenPair, status, err := h.qrService.ClaimQRLogin(c.Request.Context(), req.ID, string(req.PollToken), clientInfo(c), dpopJKT)
+	if err != nil {
+		if err == service.ErrQRLoginPending {
+			c.JSON(http.StatusAccepted, models.APIResponse{
+				Success: false,
+				Message: "Waiting for approval",
+				Data:    gin.H{"status": status},
+			})
+			return
+		}
+		qrLoginErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "QR login approved",
+		Data:    tokenPair,
+	})
+}
+
+func qrLoginErrorResponse(c *gin.Context, err error) {
+	var statusCode int
+	var errorMsg string
+
+	switch err {
+	case storage.ErrQRCodeNotFound:
+		statusCode = http.StatusNotFound
+		errorMsg = "QR login not found"
+	case storage.ErrQRCodeExpired:
+		statusCode = http.StatusGone
+		errorMsg = "QR login has expired"
+	case storage.ErrQRCodeUsed:
+		statusCode = http.StatusConflict
+		errorMsg = "QR login has already been used"
+	case service.ErrQRCodeValidationFailed:
+		statusCode = http.StatusBadRequest
+		errorMsg = "Invalid QR code"
+	case service.ErrQRLoginDenied:
+		statusCode = http.StatusForbidden
+		errorMsg = "QR login was denied"
+	case service.ErrQRLoginNotScannable, service.ErrQRLoginScannedByUser:
+		statusCode = http.StatusConflict
+		errorMsg = err.Error()
+	case service.ErrInvalidScope:
+		statusCode = http.StatusForbidden
+		errorMsg = "Your account may not grant the requested scope"
+	default:
+		statusCode = http.StatusInternalServerError
+		errorMsg = "QR login failed: " + err.Error()
+	}
+
+	c.JSON(statusCode, models.APIResponse{
+		Success:   false,
+		Error:     errorMsg,
+		RequestID: requestID(c),
+	})
+}
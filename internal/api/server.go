@@ -1,33 +1,63 @@
 package api
 
 import (
+	"context"
+	"net/http"
+	"rotate-token-demo/internal/clock"
 	"rotate-token-demo/internal/config"
+	"rotate-token-demo/internal/health"
+	"rotate-token-demo/internal/metrics"
 	"rotate-token-demo/internal/service"
+	"rotate-token-demo/internal/tracing"
+	"sync"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 type Server struct {
+	httpServer  *http.Server
 	router      *gin.Engine
 	handlers    *Handlers
 	authService *service.AuthService
 	qrService   *service.QRCodeService
-	config      *config.Config
+	metrics     *metrics.Metrics
+	health      *health.Checker
+	config      *config.Live
+
+	// draining is closed when Shutdown begins, so that streaming handlers
+	// return instead of holding their connections open until the deadline.
+	draining     chan struct{}
+	drainingOnce sync.Once
 }
 
-func NewServer(authService *service.AuthService, qrService *service.QRCodeService, config *config.Config) *Server {
+func NewServer(authService *service.AuthService, qrService *service.QRCodeService, metrics *metrics.Metrics, health *health.Checker, config *config.Live, clock clock.Clock) *Server {
 	gin.SetMode(gin.ReleaseMode)
 
 	router := gin.New()
-	handlers := NewHandlers(authService, qrService)
+	draining := make(chan struct{})
+	handlers := NewHandlers(authService, qrService, clock, draining)
 
+	// The listener settings are read once; changing them needs a restart.
+	cfg := config.Get()
+	// Validate has rejected malformed trusted_proxies, so this cannot fail.
+	_ = router.SetTrustedProxies(cfg.TrustedProxies)
 	server := &Server{
 		router:      router,
 		handlers:    handlers,
 		authService: authService,
 		qrService:   qrService,
+		metrics:     metrics,
+		health:      health,
 		config:      config,
+		draining:    draining,
+		httpServer: &http.Server{
+			Addr:         ":" + cfg.Port,
+			Handler:      router,
+			ReadTimeout:  cfg.HTTPReadTimeout,
+			WriteTimeout: cfg.HTTPWriteTimeout,
+			IdleTimeout:  cfg.HTTPIdleTimeout,
+		},
 	}
 
 	server.setupMiddleware()
@@ -38,17 +68,36 @@ func NewServer(authService *service.AuthService, qrService *service.QRCodeServic
 
 func (s *Server) setupMiddleware() {
 	corsConfig := cors.DefaultConfig()
-	corsConfig.AllowOrigins = s.config.CORSAllowOrigins
+	// Origins are looked up per request so that a config reload applies to
+	// them without rebuilding the middleware chain.
+	corsConfig.AllowOriginFunc = func(origin string) bool {
+		return containsString(s.config.Get().CORSAllowOrigins, origin)
+	}
 	corsConfig.AllowCredentials = true
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "DPoP", "X-Poll-Token", "X-Approval-Token", "X-Device-Fingerprint", "traceparent", "tracestate", RequestIDHeader}
+	corsConfig.ExposeHeaders = []string{"DPoP-Nonce", "WWW-Authenticate", "ETag", RequestIDHeader}
 	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"}
 
+	s.router.Use(TrustedProxyMiddleware(s.config.Get().TrustedProxyNets()))
+	s.router.Use(RequestIDMiddleware())
+	s.router.Use(tracing.Middleware())
+	s.router.Use(s.metrics.Middleware())
 	s.router.Use(cors.New(corsConfig))
 	s.router.Use(LoggingMiddleware())
 	s.router.Use(ErrorHandlerMiddleware())
 }
 
 func (s *Server) setupRoutes() {
+	s.router.GET("/metrics", s.metrics.Handler())
+	s.router.GET("/livez", LivenessHandler())
+	s.router.GET("/readyz", ReadinessHandler(s.health))
+
+	oauth := s.router.Group("/oauth")
+	{
+		oauth.POST("/device_authorization", s.handlers.DeviceAuthorization)
+		oauth.POST("/token", s.handlers.OAuthToken)
+	}
+
 	v1 := s.router.Group("/api/v1")
 	{
 		v1.GET("/health", s.handlers.HealthCheck)
@@ -61,16 +110,42 @@ func (s *Server) setupRoutes() {
 		}
 
 		protected := v1.Group("/")
-		protected.Use(AuthMiddleware(s.authService))
+		protected.Use(AuthMiddleware(s.authService, WithAudience(s.config.Get().AccessTokenAudience)))
 		{
 			protected.POST("/auth/logout", s.handlers.Logout)
-			protected.GET("/profile", s.handlers.GetProfile)
-			protected.GET("/protected", s.handlers.Protected)
-			protected.POST("/qr/generate", s.handlers.GenerateQRCode)
+
+			profile := protected.Group("/")
+			profile.Use(RequireScope("profile"))
+			{
+				profile.GET("/profile", s.handlers.GetProfile)
+				profile.GET("/protected", s.handlers.Protected)
+			}
+
+			qrScoped := protected.Group("/")
+			qrScoped.Use(RequireScope("qr"))
+			{
+				qrScoped.GET("/qr", s.handlers.ListQRCodes)
+				qrScoped.POST("/qr/generate", s.handlers.GenerateQRCode)
+				qrScoped.DELETE("/qr/:id", s.handlers.RevokeQRCode)
+				qrScoped.GET("/qr/:id/image", s.handlers.QRCodeImage)
+				qrScoped.POST("/device/verify", s.handlers.VerifyDeviceCode)
+				qrScoped.POST("/qr/login/scan", s.handlers.ScanQRLogin)
+				qrScoped.POST("/qr/login/decide", s.handlers.DecideQRLogin)
+				qrScoped.POST("/qr/approvals", s.handlers.RequestApproval)
+				qrScoped.POST("/qr/approvals/scan", s.handlers.ScanApproval)
+				qrScoped.POST("/qr/approvals/decide", s.handlers.DecideApproval)
+				qrScoped.POST("/qr/approvals/:id/token", s.handlers.ClaimApprovalToken)
+			}
+
+			account := protected.Group("/account")
+			{
+				account.POST("/password", RequireApproval(s.qrService, "change_password"), s.handlers.ChangePassword)
+				account.DELETE("", RequireApproval(s.qrService, "delete_account"), s.handlers.DeleteAccount)
+			}
 		}
 
 		debug := v1.Group("/debug")
-		debug.Use(AuthMiddleware(s.authService))
+		debug.Use(AuthMiddleware(s.authService, WithAudience(s.config.Get().AccessTokenAudience)))
 		{
 			debug.GET("/token-info", s.handlers.GetTokenInfo)
 		}
@@ -84,16 +159,36 @@ func (s *Server) setupRoutes() {
 		qr := v1.Group("/qr")
 		{
 			qr.POST("/validate", s.handlers.ValidateQRCode)
+			qr.POST("/code", s.handlers.RedeemUserCode)
+			qr.POST("/login", s.handlers.StartQRLogin)
+			qr.POST("/login/token", s.handlers.ClaimQRLogin)
+			qr.GET("/:id/events", s.handlers.QRCodeEvents)
+			qr.GET("/:id/status", s.handlers.QRCodeStatus)
 		}
 
 		admin := v1.Group("/admin")
-		admin.Use(AuthMiddleware(s.authService))
+		admin.Use(AuthMiddleware(s.authService, WithAudience(s.config.Get().AccessTokenAudience)), RequireScope("admin"))
 		{
 			admin.GET("/database", s.handlers.GetDatabaseView)
+			admin.GET("/audit", s.handlers.GetAuditLog)
+			admin.POST("/config/reload", s.handlers.ReloadConfig)
 		}
 	}
 }
 
+// Start serves until Shutdown is called, after which it returns nil.
 func (s *Server) Start() error {
-	return s.router.Run(":" + s.config.Port)
+	if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections, ends open event streams and long polls,
+// and waits for in-flight requests to finish or ctx to expire.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.drainingOnce.Do(func() {
+		close(s.draining)
+	})
+	return s.httpServer.Shutdown(ctx)
 }
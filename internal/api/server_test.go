@@ -0,0 +1,219 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rotate-token-demo/internal/clock"
+	"rotate-token-demo/internal/config"
+	"rotate-token-demo/internal/health"
+	"rotate-token-demo/internal/metrics"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/service"
+	"rotate-token-demo/internal/storage"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type testServer struct {
+	*Server
+	users *storage.InMemoryUserStorage
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	return newTestServerWithConfig(t, config.New())
+}
+
+func newTestServerWithConfig(t *testing.T, cfg *config.Config) *testServer {
+	t.Helper()
+
+	users := storage.NewInMemoryUserStorage(clock.System)
+	tokens := storage.NewInMemoryTokenStorage(clock.System)
+	qrCodes := storage.NewInMemoryQRCodeStorage(clock.System)
+	dpopReplay := storage.NewInMemoryDPoPReplayStorage(clock.System)
+	attempts := storage.NewInMemoryAttemptStorage(clock.System)
+	t.Cleanup(func() {
+		tokens.Close()
+		qrCodes.Close()
+		dpopReplay.Close()
+		attempts.Close()
+	})
+
+	live := config.NewLive(cfg, nil)
+	authService := service.NewAuthService(users, tokens, dpopReplay, storage.NewInMemoryAuditLog(100), live, clock.System)
+	qrService := service.NewQRCodeService(qrCodes, users, tokens, attempts, authService)
+
+	server := NewServer(authService, qrService, metrics.New(tokens, qrCodes), health.NewChecker(), live, clock.System)
+	return &testServer{Server: server, users: users}
+}
+
+// do sends body as JSON with an optional bearer token and decodes the response.
+func (s *testServer) do(t *testing.T, method, path, token string, body interface{}) (int, models.APIResponse) {
+	t.Helper()
+	return s.doWithHeader(t, method, path, token, nil, body)
+}
+
+func (s *testServer) doWithHeader(t *testing.T, method, path, token string, header http.Header, body interface{}) (int, models.APIResponse) {
+	t.Helper()
+
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+
+	var resp models.APIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func (s *testServer) login(t *testing.T, username, password, scope string) (int, string) {
+	t.Helper()
+
+	code, resp := s.do(t, http.MethodPost, "/api/v1/auth/login", "", models.LoginRequest{
+		Username: username,
+		Password: password,
+		Scope:    scope,
+	})
+	if code != http.StatusOK {
+		return code, ""
+	}
+	data, _ := resp.Data.(map[string]interface{})
+	token, _ := data["access_token"].(string)
+	return code, token
+}
+
+func TestAdminRoutesRejectRegisteredUser(t *testing.T) {
+	s := newTestServer(t)
+
+	code, _ := s.do(t, http.MethodPost, "/api/v1/auth/register", "", models.RegisterRequest{
+		Username: "mallory",
+		Email:    "mallory@example.com",
+		Password: "password123",
+	})
+	if code != http.StatusCreated && code != http.StatusOK {
+		t.Fatalf("register: got status %d", code)
+	}
+
+	code, token := s.login(t, "mallory", "password123", "")
+	if code != http.StatusOK {
+		t.Fatalf("login: got status %d", code)
+	}
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/api/v1/admin/database"},
+		{http.MethodGet, "/api/v1/admin/audit"},
+		{http.MethodPost, "/api/v1/admin/config/reload"},
+	} {
+		if code, _ := s.do(t, route.method, route.path, token, nil); code != http.StatusForbidden {
+			t.Errorf("%s %s: got status %d, want %d", route.method, route.path, code, http.StatusForbidden)
+		}
+	}
+
+	if code, _ := s.login(t, "mallory", "password123", "profile admin"); code != http.StatusBadRequest {
+		t.Errorf("login with admin scope: got status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminRoutesAllowAdminRole(t *testing.T) {
+	s := newTestServer(t)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.users.CreateUser(context.Background(), &models.User{
+		ID:       "admin-id",
+		Username: "admin",
+		Email:    "admin@example.com",
+		Password: string(hash),
+		Roles:    []string{models.RoleAdmin},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, token := s.login(t, "admin", "password123", "")
+	if code, _ := s.do(t, http.MethodGet, "/api/v1/admin/audit", token, nil); code != http.StatusForbidden {
+		t.Errorf("default scope: got status %d, want %d", code, http.StatusForbidden)
+	}
+
+	_, token = s.login(t, "admin", "password123", "profile admin")
+	if code, _ := s.do(t, http.MethodGet, "/api/v1/admin/audit", token, nil); code != http.StatusOK {
+		t.Errorf("admin scope: got status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestTokenExchangeRequiresAuthenticatedAllowedClient(t *testing.T) {
+	cfg := config.New()
+	cfg.ExchangeAudiences = map[string][]string{"billing-api": {"billing-gateway"}}
+	cfg.ClientSecrets = map[string][]string{
+		"billing-gateway": {"gateway-secret"},
+		"mobile-app":      {"mobile-secret"},
+	}
+	s := newTestServerWithConfig(t, cfg)
+
+	if code, _ := s.do(t, http.MethodPost, "/api/v1/auth/register", "", models.RegisterRequest{
+		Username: "alice",
+		Email:    "alice@example.com",
+		Password: "password123",
+	}); code != http.StatusCreated && code != http.StatusOK {
+		t.Fatalf("register: got status %d", code)
+	}
+	_, token := s.login(t, "alice", "password123", "")
+
+	basic := func(id, secret string) http.Header {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.SetBasicAuth(id, secret)
+		return http.Header{"Authorization": req.Header["Authorization"]}
+	}
+
+	for _, tc := range []struct {
+		name                   string
+		header                 http.Header
+		clientID, clientSecret string
+		audience, subjectToken string
+		wantCode               int
+		wantError              string
+	}{
+		{"basic auth", basic("billing-gateway", "gateway-secret"), "", "", "billing-api", token, http.StatusOK, ""},
+		{"post auth", nil, "billing-gateway", "gateway-secret", "billing-api", token, http.StatusOK, ""},
+		{"unauthenticated client_id", nil, "billing-gateway", "", "billing-api", token, http.StatusUnauthorized, "invalid_client"},
+		{"wrong secret", basic("billing-gateway", "mobile-secret"), "", "", "billing-api", token, http.StatusUnauthorized, "invalid_client"},
+		{"two methods", basic("billing-gateway", "gateway-secret"), "", "gateway-secret", "billing-api", token, http.StatusBadRequest, "invalid_request"},
+		{"missing client", nil, "", "", "billing-api", token, http.StatusBadRequest, "invalid_request"},
+		{"other client", basic("mobile-app", "mobile-secret"), "", "", "billing-api", token, http.StatusBadRequest, "unauthorized_client"},
+		{"unlisted audience", basic("billing-gateway", "gateway-secret"), "", "", "rotate-token-demo-internal", token, http.StatusBadRequest, "invalid_target"},
+		{"invalid subject token", basic("billing-gateway", "gateway-secret"), "", "", "billing-api", "not-a-token", http.StatusBadRequest, "invalid_grant"},
+	} {
+		code, resp := s.doWithHeader(t, http.MethodPost, "/oauth/token", "", tc.header, models.OAuthTokenRequest{
+			GrantType:        grantTypeTokenExchange,
+			ClientID:         tc.clientID,
+			ClientSecret:     tc.clientSecret,
+			SubjectToken:     tc.subjectToken,
+			SubjectTokenType: service.TokenTypeAccessToken,
+			Audience:         tc.audience,
+		})
+		if code != tc.wantCode || resp.Error != tc.wantError {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, code, resp.Error, tc.wantCode, tc.wantError)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package clock
+
+import "time"
+
+// Clock tells the current time. Services and storages read the time only
+// through a Clock, so expiry, rotation and cleanup can be driven by a fake
+// clock instead of waiting.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the wall clock.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
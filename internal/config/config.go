@@ -1,33 +1,192 @@
 package config
 
 import (
-	"os"
+	"net"
 	"time"
 )
 
+// Config holds every setting of the server. The config tag names a setting in
+// the config file; its environment variable is the upper-cased name unless an
+// env tag says otherwise, and its flag is the name with dashes. Settings tagged
+// secret are masked by Print; settings tagged reload take effect when a Live
+// config is reloaded, all others only on restart.
 type Config struct {
-	Port                string
-	JWTSecret           string
-	AccessTokenExpiry   time.Duration
-	RefreshTokenExpiry  time.Duration
-	EnableTokenRotation bool
-	CORSAllowOrigins    []string
+	// Environment is "development" or "production"; production refuses unsafe
+	// settings such as the built-in JWT secret.
+	Environment string `config:"environment" env:"APP_ENV"`
+	Port        string `config:"port"`
+	JWTSecret   string `config:"jwt_secret" secret:"true" reload:"true"`
+	// JWTPreviousSecrets are retired signing secrets that are still accepted
+	// when verifying, so rotating jwt_secret does not log everyone out.
+	JWTPreviousSecrets  []string      `config:"jwt_previous_secrets" secret:"true" reload:"true"`
+	AccessTokenExpiry   time.Duration `config:"access_token_expiry" reload:"true"`
+	RefreshTokenExpiry  time.Duration `config:"refresh_token_expiry" reload:"true"`
+	TokenExchangeExpiry time.Duration `config:"token_exchange_expiry" reload:"true"`
+	EnableTokenRotation bool          `config:"enable_token_rotation" reload:"true"`
+	CORSAllowOrigins    []string      `config:"cors_allow_origins" reload:"true"`
+	SupportedScopes     []string      `config:"supported_scopes"`
+	// DefaultScopes are granted when a request names no scope. Privileged
+	// scopes such as admin must be requested explicitly.
+	DefaultScopes       []string            `config:"default_scopes"`
+	AccessTokenAudience string              `config:"access_token_audience"`
+	ExpectedAudiences   []string            `config:"expected_audiences"`
+	ClientAudiences     map[string][]string `config:"client_audiences"`
+	// ExchangeAudiences maps each audience a token can be exchanged for to the
+	// client_ids allowed to request it. Audiences not listed are refused.
+	ExchangeAudiences map[string][]string `config:"exchange_audiences"`
+	// ClientSecrets maps a client_id to the secrets it may authenticate with at
+	// the token endpoint; listing two lets a secret be rotated without downtime.
+	ClientSecrets      map[string][]string `config:"client_secrets" secret:"true" reload:"true"`
+	DPoPProofMaxAge    time.Duration       `config:"dpop_proof_max_age" reload:"true"`
+	DPoPNonceLifetime  time.Duration       `config:"dpop_nonce_lifetime"`
+	DPoPRequireNonce   bool                `config:"dpop_require_nonce"`
+	DeviceCodeExpiry   time.Duration       `config:"device_code_expiry" reload:"true"`
+	QRLoginExpiry      time.Duration       `config:"qr_login_expiry" reload:"true"`
+	QRCodeTTL          time.Duration       `config:"qr_code_ttl" reload:"true"`
+	QRCodeMinTTL       time.Duration       `config:"qr_code_min_ttl" reload:"true"`
+	QRCodeMaxTTL       time.Duration       `config:"qr_code_max_ttl" reload:"true"`
+	QRMaxActivePerUser int                 `config:"qr_max_active_per_user" reload:"true"`
+	QRLogoPath         string              `config:"qr_logo_path"`
+	DevicePollInterval time.Duration       `config:"device_poll_interval" reload:"true"`
+	VerificationURI    string              `config:"verification_uri"`
+	// ApprovalActions name the routes mounted behind RequireApproval; only
+	// change_password and delete_account exist. There are no API keys to
+	// create, so that action is not offered.
+	ApprovalActions     []string      `config:"approval_actions"`
+	ApprovalQRExpiry    time.Duration `config:"approval_qr_expiry" reload:"true"`
+	ApprovalTokenExpiry time.Duration `config:"approval_token_expiry" reload:"true"`
+	ApprovalAudience    string        `config:"approval_audience"`
+	// Brute-force protection for typed user codes.
+	UserCodeMaxFailures        int           `config:"user_code_max_failures" reload:"true"`
+	UserCodeFailureWindow      time.Duration `config:"user_code_failure_window" reload:"true"`
+	UserCodeLockout            time.Duration `config:"user_code_lockout" reload:"true"`
+	UserCodeMaxAttemptsPerCode int           `config:"user_code_max_attempts_per_code" reload:"true"`
+	// Audit trail: "memory", "file" (JSON lines at AuditLogPath) or "sql"
+	// (AuditLogDriver must name a database/sql driver linked into the binary).
+	AuditLogBackend  string `config:"audit_log_backend"`
+	AuditLogPath     string `config:"audit_log_path"`
+	AuditLogDriver   string `config:"audit_log_driver"`
+	AuditLogDSN      string `config:"audit_log_dsn" secret:"true"`
+	AuditLogCapacity int    `config:"audit_log_capacity"`
+	// Tracing: "none", "stdout" or "otlp". The OTLP exporter reads its endpoint
+	// and headers from the standard OTEL_EXPORTER_OTLP_* variables.
+	TracingExporter    string `config:"tracing_exporter"`
+	TracingServiceName string `config:"tracing_service_name" env:"OTEL_SERVICE_NAME"`
+	// Logging: LogFormat is "text" or "json"; LogLevel is debug, info, warn or error.
+	LogFormat string `config:"log_format"`
+	LogLevel  string `config:"log_level"`
+	// HTTP server timeouts. The write timeout is extended by the SSE and
+	// long-poll handlers for as long as they stream.
+	HTTPReadTimeout  time.Duration `config:"http_read_timeout"`
+	HTTPWriteTimeout time.Duration `config:"http_write_timeout"`
+	HTTPIdleTimeout  time.Duration `config:"http_idle_timeout"`
+	// TrustedProxies are the addresses or CIDRs of the reverse proxies whose
+	// X-Forwarded-* headers are believed. Empty means the server is reached
+	// directly and those headers are ignored.
+	TrustedProxies []string `config:"trusted_proxies"`
+	// ShutdownTimeout bounds how long in-flight requests may drain on SIGTERM.
+	ShutdownTimeout time.Duration `config:"shutdown_timeout"`
+
+	// sources records where each setting's value came from, for Print.
+	sources map[string]string
 }
 
+// DefaultJWTSecret is the well-known development secret. Validate rejects it in
+// production.
+const DefaultJWTSecret = "your-super-secret-jwt-key-change-this-in-production"
+
+const EnvironmentProduction = "production"
+
+// New returns the built-in defaults. Load layers the config file, environment
+// and flags on top of them.
 func New() *Config {
 	return &Config{
-		Port:                getEnv("PORT", "8080"),
-		JWTSecret:           getEnv("JWT_SECRET", "your-super-secret-jwt-key-change-this-in-production"),
+		Environment:         "development",
+		Port:                "8080",
+		JWTSecret:           DefaultJWTSecret,
+		JWTPreviousSecrets:  []string{},
 		AccessTokenExpiry:   time.Minute * 2,
 		RefreshTokenExpiry:  time.Minute * 30,
+		TokenExchangeExpiry: time.Minute * 1,
 		EnableTokenRotation: true,
 		CORSAllowOrigins:    []string{"http://localhost:3000", "http://localhost:5173"},
+		SupportedScopes:     []string{"profile", "qr", "admin"},
+		DefaultScopes:       []string{"profile", "qr"},
+		AccessTokenAudience: "rotate-token-demo-api",
+		ExpectedAudiences:   []string{"rotate-token-demo-api"},
+		ClientAudiences:     map[string][]string{},
+		ExchangeAudiences:   map[string][]string{},
+		ClientSecrets:       map[string][]string{},
+		DPoPProofMaxAge:     time.Minute * 1,
+		DPoPNonceLifetime:   time.Minute * 5,
+		DPoPRequireNonce:    false,
+		DeviceCodeExpiry:    time.Minute * 10,
+		QRLoginExpiry:       time.Minute * 2,
+		QRCodeTTL:           time.Minute * 5,
+		QRCodeMinTTL:        time.Second * 30,
+		QRCodeMaxTTL:        time.Minute * 15,
+		QRMaxActivePerUser:  5,
+		QRLogoPath:          "",
+		DevicePollInterval:  time.Second * 5,
+		VerificationURI:     "http://localhost:3000/device",
+		ApprovalActions:     []string{"change_password", "delete_account"},
+		ApprovalQRExpiry:    time.Minute * 2,
+		ApprovalTokenExpiry: time.Minute * 1,
+		ApprovalAudience:    "rotate-token-demo-approval",
+
+		UserCodeMaxFailures:        5,
+		UserCodeFailureWindow:      time.Minute * 15,
+		UserCodeLockout:            time.Minute * 15,
+		UserCodeMaxAttemptsPerCode: 3,
+
+		AuditLogBackend:  "memory",
+		AuditLogPath:     "audit.log",
+		AuditLogDriver:   "",
+		AuditLogDSN:      "",
+		AuditLogCapacity: 10000,
+
+		TracingExporter:    "none",
+		TracingServiceName: "rotate-token-demo",
+
+		LogFormat: "text",
+		LogLevel:  "info",
+
+		HTTPReadTimeout:  time.Second * 15,
+		HTTPWriteTimeout: time.Second * 30,
+		HTTPIdleTimeout:  time.Second * 120,
+		TrustedProxies:   []string{},
+		ShutdownTimeout:  time.Second * 30,
 	}
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// TrustedProxyNets returns trusted_proxies as networks, single addresses as
+// /32 or /128. Entries that do not parse are skipped; Validate reports them.
+func (c *Config) TrustedProxyNets() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, proxy := range c.TrustedProxies {
+		if n, ok := parseTrustedProxy(proxy); ok {
+			nets = append(nets, n)
+		}
 	}
-	return defaultValue
+	return nets
+}
+
+func parseTrustedProxy(proxy string) (*net.IPNet, bool) {
+	if _, n, err := net.ParseCIDR(proxy); err == nil {
+		return n, true
+	}
+	ip := net.ParseIP(proxy)
+	if ip == nil {
+		return nil, false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, true
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, true
+}
+
+// VerificationSecrets returns the secrets accepted when verifying tokens and
+// MACs: the current jwt_secret first, then the previous ones.
+func (c *Config) VerificationSecrets() []string {
+	return append([]string{c.JWTSecret}, c.JWTPreviousSecrets...)
 }
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Live holds the configuration of a running server and swaps in a new one on
+// Reload. Readers call Get for every use instead of keeping the *Config, so a
+// reload takes effect on the next request; the Config returned by Get must not
+// be modified.
+type Live struct {
+	current atomic.Pointer[Config]
+	args    []string
+
+	// mu serializes reloads; readers never take it.
+	mu sync.Mutex
+}
+
+// ReloadResult lists the settings a reload changed. Applied settings are live;
+// RestartRequired settings changed in the sources but keep their old value
+// until the process restarts.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// NewLive wraps cfg, which was loaded from args. Reload loads from the same
+// args again, so the config file, environment and flags are all re-read.
+func NewLive(cfg *Config, args []string) *Live {
+	l := &Live{args: args}
+	l.current.Store(cfg)
+	return l
+}
+
+// Get returns the current configuration.
+func (l *Live) Get() *Config {
+	return l.current.Load()
+}
+
+// Reload re-reads the configuration and atomically replaces the settings
+// tagged reload. If the new configuration is invalid, nothing changes.
+func (l *Live) Reload() (*ReloadResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	loaded, err := Load(l.args)
+	if err != nil {
+		return nil, err
+	}
+
+	current := l.Get()
+	next := *current
+	next.sources = make(map[string]string, len(current.sources))
+	for key, source := range current.sources {
+		next.sources[key] = source
+	}
+
+	result := &ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	nextSettings := next.settings()
+	for i, s := range loaded.settings() {
+		if reflect.DeepEqual(s.value.Interface(), nextSettings[i].value.Interface()) {
+			continue
+		}
+		if !s.reload {
+			result.RestartRequired = append(result.RestartRequired, s.key)
+			continue
+		}
+		nextSettings[i].value.Set(s.value)
+		if source, ok := loaded.sources[s.key]; ok {
+			next.sources[s.key] = source
+		} else {
+			delete(next.sources, s.key)
+		}
+		result.Applied = append(result.Applied, s.key)
+	}
+
+	// Reloadable settings are checked against each other and the ones kept
+	// from the current configuration, e.g. qr_code_ttl against its bounds.
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+
+	l.current.Store(&next)
+	return result, nil
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is one tagged field of a Config.
+type setting struct {
+	key    string
+	env    string
+	secret bool
+	reload bool
+	value  reflect.Value
+}
+
+func (c *Config) settings() []setting {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	var settings []setting
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("config")
+		if key == "" {
+			continue
+		}
+		env := field.Tag.Get("env")
+		if env == "" {
+			env = strings.ToUpper(key)
+		}
+		settings = append(settings, setting{
+			key:    key,
+			env:    env,
+			secret: field.Tag.Get("secret") == "true",
+			reload: field.Tag.Get("reload") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return settings
+}
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and the command-line flags, each overriding the one before, and
+// validates the result.
+func Load(args []string) (*Config, error) {
+	cfg, err := Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Parse is Load without validation. The config file is named by the -config
+// flag or the CONFIG_FILE variable; its format follows the extension (.yaml,
+// .yml or .toml).
+func Parse(args []string) (*Config, error) {
+	cfg := New()
+	cfg.sources = make(map[string]string)
+	settings := cfg.settings()
+
+	flags := flag.NewFlagSet("rotate-token-demo", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file (env CONFIG_FILE)")
+
+	// Flags are collected first and applied last, so they win over the file
+	// and the environment whatever their position on the command line.
+	type flagValue struct {
+		setting setting
+		raw     string
+	}
+	var flagValues []flagValue
+	for _, s := range settings {
+		s := s
+		name := strings.ReplaceAll(s.key, "_", "-")
+		usage := fmt.Sprintf("sets %s (env %s)", s.key, s.env)
+		collect := func(raw string) error {
+			flagValues = append(flagValues, flagValue{setting: s, raw: raw})
+			return nil
+		}
+		if s.value.Kind() == reflect.Bool {
+			flags.BoolFunc(name, usage, collect)
+		} else {
+			flags.Func(name, usage, collect)
+		}
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile, settings); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", *configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		raw, ok := os.LookupEnv(s.env)
+		if !ok || raw == "" {
+			continue
+		}
+		if err := setValue(s.value, raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.env, err)
+		}
+		cfg.sources[s.key] = sourceEnv
+	}
+
+	for _, f := range flagValues {
+		if err := setValue(f.setting.value, f.raw); err != nil {
+			return nil, fmt.Errorf("-%s: %w", strings.ReplaceAll(f.setting.key, "_", "-"), err)
+		}
+		cfg.sources[f.setting.key] = sourceFlag
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string, settings []setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unsupported format %q, use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return err
+	}
+
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	for key, raw := range values {
+		s, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if err := setValue(s.value, raw); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		c.sources[key] = sourceFile
+	}
+	return nil
+}
+
+// setValue stores raw in v. Strings from the environment and flags are parsed:
+// lists are comma-separated and maps are written as "k1=a,b;k2=c". Values from
+// a config file keep their native types, except durations, which are always
+// strings such as "90s" or "15m".
+func setValue(v reflect.Value, raw interface{}) error {
+	if v.Type() == durationType {
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("duration must be a string with a unit, such as \"30s\"")
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %v", raw)
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		switch b := raw.(type) {
+		case bool:
+			v.SetBool(b)
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			if err != nil {
+				return err
+			}
+			v.SetBool(parsed)
+		default:
+			return fmt.Errorf("expected a boolean, got %v", raw)
+		}
+	case reflect.Int:
+		switch n := raw.(type) {
+		case int:
+			v.SetInt(int64(n))
+		case int64:
+			v.SetInt(n)
+		case string:
+			parsed, err := strconv.Atoi(n)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(parsed))
+		default:
+			return fmt.Errorf("expected an integer, got %v", raw)
+		}
+	case reflect.Slice:
+		list, err := stringList(raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		m, err := stringListMap(raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+func stringList(raw interface{}) ([]string, error) {
+	switch list := raw.(type) {
+	case string:
+		var values []string
+		for _, item := range strings.Split(list, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		return values, nil
+	case []interface{}:
+		values := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a list of strings, got %v", item)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("expected a list of strings, got %v", raw)
+	}
+}
+
+func stringListMap(raw interface{}) (map[string][]string, error) {
+	m := make(map[string][]string)
+	switch entries := raw.(type) {
+	case string:
+		for _, entry := range strings.Split(entries, ";") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			key, list, ok := strings.Cut(entry, "=")
+			if !ok {
+				return nil, fmt.Errorf("expected key=value, got %q", entry)
+			}
+			values, _ := stringList(list)
+			m[strings.TrimSpace(key)] = values
+		}
+	case map[string]interface{}:
+		for key, list := range entries {
+			values, err := stringList(list)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			m[key] = values
+		}
+	default:
+		return nil, fmt.Errorf("expected a map of string lists, got %v", raw)
+	}
+	return m, nil
+}
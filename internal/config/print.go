@@ -0,0 +1,69 @@
+package config
+
+import (
+	"io"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const maskedSecret = "********"
+
+// Print writes the effective settings as a YAML config file, each annotated
+// with where its value came from. Secrets are masked.
+func (c *Config) Print(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+
+	for _, s := range c.settings() {
+		var value interface{}
+		switch {
+		case s.secret && s.value.Kind() == reflect.Slice:
+			masked := make([]string, s.value.Len())
+			for i := range masked {
+				masked[i] = maskedSecret
+			}
+			value = masked
+		case s.secret && s.value.Kind() == reflect.Map:
+			masked := make(map[string][]string, s.value.Len())
+			iter := s.value.MapRange()
+			for iter.Next() {
+				secrets := make([]string, iter.Value().Len())
+				for i := range secrets {
+					secrets[i] = maskedSecret
+				}
+				masked[iter.Key().String()] = secrets
+			}
+			value = masked
+		case s.secret && s.value.String() != "":
+			value = maskedSecret
+		case s.value.Type() == durationType:
+			value = time.Duration(s.value.Int()).String()
+		default:
+			value = s.value.Interface()
+		}
+
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(value); err != nil {
+			return err
+		}
+
+		source := c.sources[s.key]
+		if source == "" {
+			source = sourceDefault
+		}
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: s.key, LineComment: source}
+		if source == sourceEnv {
+			keyNode.LineComment = source + " " + s.env
+		}
+
+		doc.Content = append(doc.Content, keyNode, valueNode)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
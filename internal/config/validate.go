@@ -0,0 +1,119 @@
+package config
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Validate reports every invalid setting at once, so a broken deployment can be
+// fixed in one go.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Environment == "development" || c.Environment == EnvironmentProduction,
+		"environment must be development or production, got %q", c.Environment)
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "port must be a TCP port number, got %q", c.Port)
+
+	check(c.JWTSecret != "", "jwt_secret must be set")
+	if c.Environment == EnvironmentProduction {
+		check(c.JWTSecret != DefaultJWTSecret, "jwt_secret must not be the built-in default in production")
+		check(len(c.JWTSecret) >= 32, "jwt_secret must be at least 32 bytes in production")
+	}
+	for i, secret := range c.JWTPreviousSecrets {
+		check(secret != "", "jwt_previous_secrets[%d] must not be empty", i)
+		if c.Environment == EnvironmentProduction {
+			check(secret != DefaultJWTSecret, "jwt_previous_secrets[%d] must not be the built-in default in production", i)
+		}
+	}
+
+	for _, s := range c.settings() {
+		if s.value.Type() == durationType {
+			check(s.value.Int() > 0, "%s must be positive", s.key)
+		}
+	}
+	// A nonce must outlive the round trip in which the client echoes it.
+	check(c.DPoPNonceLifetime >= time.Second, "dpop_nonce_lifetime must be at least 1s, got %s", c.DPoPNonceLifetime)
+	check(c.QRCodeMinTTL <= c.QRCodeTTL && c.QRCodeTTL <= c.QRCodeMaxTTL,
+		"qr_code_ttl must lie between qr_code_min_ttl and qr_code_max_ttl")
+
+	check(c.QRMaxActivePerUser > 0, "qr_max_active_per_user must be positive")
+	check(c.UserCodeMaxFailures > 0, "user_code_max_failures must be positive")
+	check(c.UserCodeMaxAttemptsPerCode > 0, "user_code_max_attempts_per_code must be positive")
+
+	check(len(c.SupportedScopes) > 0, "supported_scopes must not be empty")
+	check(len(c.DefaultScopes) > 0, "default_scopes must not be empty")
+	for _, scope := range c.DefaultScopes {
+		check(containsString(c.SupportedScopes, scope), "default_scopes entry %q is not in supported_scopes", scope)
+	}
+	check(containsString(c.ExpectedAudiences, c.AccessTokenAudience),
+		"expected_audiences must include access_token_audience %q", c.AccessTokenAudience)
+
+	for audience, clients := range c.ExchangeAudiences {
+		check(len(clients) > 0, "exchange_audiences entry %q must list at least one client_id", audience)
+		for _, client := range clients {
+			check(len(c.ClientSecrets[client]) > 0, "exchange_audiences client %q has no client_secrets entry", client)
+		}
+	}
+	for client, secrets := range c.ClientSecrets {
+		for i, secret := range secrets {
+			check(secret != "", "client_secrets[%q][%d] must not be empty", client, i)
+		}
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		_, ok := parseTrustedProxy(proxy)
+		check(ok, "trusted_proxies entry %q is not an IP address or CIDR", proxy)
+	}
+
+	for _, action := range c.ApprovalActions {
+		check(action == "change_password" || action == "delete_account",
+			"approval_actions entry %q must be change_password or delete_account", action)
+	}
+
+	switch c.AuditLogBackend {
+	case "memory":
+		check(c.AuditLogCapacity >= 0, "audit_log_capacity must not be negative")
+	case "file":
+		check(c.AuditLogPath != "", "audit_log_path must be set for the file audit log")
+	case "sql":
+		check(c.AuditLogDriver != "" && c.AuditLogDSN != "", "audit_log_driver and audit_log_dsn must be set for the sql audit log")
+		// No driver is linked by default; a build that wants the sql backend
+		// imports one for its side effect.
+		check(c.AuditLogDriver == "" || containsString(sql.Drivers(), c.AuditLogDriver),
+			"audit_log_driver %q is not linked into this binary (available: %v)", c.AuditLogDriver, sql.Drivers())
+	default:
+		check(false, "audit_log_backend must be memory, file or sql, got %q", c.AuditLogBackend)
+	}
+
+	switch c.TracingExporter {
+	case "none", "stdout", "otlp":
+	default:
+		check(false, "tracing_exporter must be none, stdout or otlp, got %q", c.TracingExporter)
+	}
+
+	check(c.LogFormat == "text" || c.LogFormat == "json", "log_format must be text or json, got %q", c.LogFormat)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log_level must be debug, info, warn or error, got %q", c.LogLevel)
+
+	return errors.Join(errs...)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"context"
+	"sync"
+)
+
+type contextKey struct{}
+
+// fields is shared by everything handling one request. The user and session are
+// usually only known after authentication, deep inside the handler chain, yet the
+// access log line written by the outermost middleware should carry them too, so
+// they are filled in place rather than by deriving a new context.
+type fields struct {
+	mu        sync.Mutex
+	requestID string
+	userID    string
+	sessionID string
+}
+
+func (f *fields) get() (requestID, userID, sessionID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requestID, f.userID, f.sessionID
+}
+
+// NewContext starts the logging fields for a request identified by requestID.
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &fields{requestID: requestID})
+}
+
+// RequestID returns the ID passed to NewContext, or "" outside a request.
+func RequestID(ctx context.Context) string {
+	if f := fieldsFrom(ctx); f != nil {
+		requestID, _, _ := f.get()
+		return requestID
+	}
+	return ""
+}
+
+// Identify attributes the request to a user and session. Fields that are already
+// set are kept, so the authenticated caller is never overwritten.
+func Identify(ctx context.Context, userID, sessionID string) {
+	f := fieldsFrom(ctx)
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.userID == "" {
+		f.userID = userID
+	}
+	if f.sessionID == "" {
+		f.sessionID = sessionID
+	}
+}
+
+func fieldsFrom(ctx context.Context) *fields {
+	f, _ := ctx.Value(contextKey{}).(*fields)
+	return f
+}
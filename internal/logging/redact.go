@@ -0,0 +1,234 @@
+package logging
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Secret holds an opaque credential (refresh token, poll token, device code)
+// that has no recognisable shape. It logs and prints as [REDACTED] under any
+// key; convert it back to a string to use the value.
+type Secret string
+
+// LogValue implements slog.LogValuer.
+func (Secret) LogValue() slog.Value { return slog.StringValue(redacted) }
+
+// String keeps the value out of fmt output as well.
+func (Secret) String() string { return redacted }
+
+// secretKeys are attribute keys (and JSON field names of logged structs) whose
+// values are always replaced, whatever they hold. Keys are compared in lower
+// case with dashes turned into underscores, so HTTP header names match too.
+var secretKeys = map[string]bool{
+	"authorization":       true,
+	"proxy_authorization": true,
+	"cookie":              true,
+	"set_cookie":          true,
+	"dpop":                true,
+	"password":            true,
+	"secret":              true,
+	"token":               true,
+	"qr_data":             true,
+	"user_code":           true,
+	"device_code":         true,
+}
+
+var secretSuffixes = []string{"_token", "_password", "_secret"}
+
+// Values that look like credentials are redacted even under an innocent key.
+var (
+	credentialPrefixes = []string{"bearer ", "dpop ", "basic "}
+	jwtPattern         = regexp.MustCompile(`^[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]*$`)
+	// Signed QR payloads: v1.<body>.<mac>, whose version segment is too short
+	// for jwtPattern.
+	qrPayloadPattern = regexp.MustCompile(`^v1\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+$`)
+)
+
+// maxRedactDepth bounds the walk over nested structs, maps and slices.
+const maxRedactDepth = 5
+
+// redactingHandler rewrites every attribute before it reaches the output. Structs,
+// maps and slices logged as values are expanded into groups so that their secret
+// fields can be dropped as well; struct fields hidden from JSON (json:"-") or
+// tagged log:"secret" are treated as secret.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	clean := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(attr slog.Attr) bool {
+		clean.AddAttrs(redactAttr(attr, 0))
+		return true
+	})
+	return h.next.Handle(ctx, clean)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		clean[i] = redactAttr(attr, 0)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(clean)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr, depth int) slog.Attr {
+	if isSecretKey(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+	return slog.Attr{Key: attr.Key, Value: redactValue(attr.Value.Resolve(), depth)}
+}
+
+func redactValue(value slog.Value, depth int) slog.Value {
+	switch value.Kind() {
+	case slog.KindString:
+		if looksLikeCredential(value.String()) {
+			return slog.StringValue(redacted)
+		}
+		return value
+	case slog.KindGroup:
+		attrs := value.Group()
+		clean := make([]slog.Attr, len(attrs))
+		for i, attr := range attrs {
+			clean[i] = redactAttr(attr, depth+1)
+		}
+		return slog.GroupValue(clean...)
+	case slog.KindAny:
+		if depth >= maxRedactDepth {
+			return slog.StringValue(redacted)
+		}
+		return redactAny(value.Any(), depth)
+	default:
+		return value
+	}
+}
+
+// redactAny expands composite values into groups. Errors, Stringers and text
+// marshalers (time.Time, net.IP, ...) are logged through their string form.
+func redactAny(v interface{}, depth int) slog.Value {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || (rv.Kind() == reflect.Pointer && rv.IsNil()) {
+		return slog.AnyValue(nil)
+	}
+
+	switch v := v.(type) {
+	case error:
+		return redactValue(slog.StringValue(v.Error()), depth)
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err != nil {
+			return slog.StringValue(redacted)
+		}
+		return redactValue(slog.StringValue(string(text)), depth)
+	case fmt.Stringer:
+		return redactValue(slog.StringValue(v.String()), depth)
+	}
+
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return slog.AnyValue(nil)
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		var attrs []slog.Attr
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			field := rt.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, hidden := jsonName(field)
+			if hidden || field.Tag.Get("log") == "secret" {
+				attrs = append(attrs, slog.String(name, redacted))
+				continue
+			}
+			if field.Anonymous {
+				// Embedded structs (e.g. jwt.RegisteredClaims) are flattened like in JSON.
+				if embedded := redactAny(rv.Field(i).Interface(), depth+1); embedded.Kind() == slog.KindGroup {
+					attrs = append(attrs, embedded.Group()...)
+					continue
+				}
+			}
+			attrs = append(attrs, redactAttr(slog.Any(name, rv.Field(i).Interface()), depth+1))
+		}
+		return slog.GroupValue(attrs...)
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return slog.StringValue(redacted)
+		}
+		attrs := make([]slog.Attr, 0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			attrs = append(attrs, redactAttr(slog.Any(iter.Key().String(), iter.Value().Interface()), depth+1))
+		}
+		return slog.GroupValue(attrs...)
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			// Raw bytes could be anything; never log them.
+			return slog.StringValue(redacted)
+		}
+		attrs := make([]slog.Attr, rv.Len())
+		for i := range attrs {
+			attrs[i] = redactAttr(slog.Any(strconv.Itoa(i), rv.Index(i).Interface()), depth+1)
+		}
+		return slog.GroupValue(attrs...)
+	case reflect.String:
+		return redactValue(slog.StringValue(rv.String()), depth)
+	default:
+		return slog.AnyValue(rv.Interface())
+	}
+}
+
+// jsonName returns the field's JSON name and whether JSON hides it.
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return field.Name, true
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name, false
+	}
+	return field.Name, false
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+	if secretKeys[key] {
+		return true
+	}
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func looksLikeCredential(s string) bool {
+	lower := strings.ToLower(s)
+	for _, prefix := range credentialPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return jwtPattern.MatchString(s) || qrPayloadPattern.MatchString(s)
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"rotate-token-demo/internal/logging"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -13,35 +14,71 @@ type User struct {
 	Password  string    `json:"-"`
 	CreateAt  time.Time `json:"created_at"`
 	LastLogin time.Time `json:"last_login,omitempty"`
+	// Roles unlock privileged scopes; see RoleAdmin.
+	Roles []string `json:"roles,omitempty"`
+}
+
+// RoleAdmin is required for the admin scope.
+const RoleAdmin = "admin"
+
+// HasRole reports whether the user holds role.
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
 }
 
 type RefreshToken struct {
-	ID          string    `json:"id"`
-	UserID      string    `json:"user_id"`
-	Token       string    `json:"token"`
-	ExpiresAt   time.Time `json:"expires_at"`
-	CreatedAt   time.Time `json:"created_at"`
-	IsRevoked   bool      `json:"is_revoked"`
-	TokenFamily string    `json:"token_family"`
+	ID          string         `json:"id"`
+	UserID      string         `json:"user_id"`
+	Token       logging.Secret `json:"token"`
+	ExpiresAt   time.Time      `json:"expires_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+	IsRevoked   bool           `json:"is_revoked"`
+	TokenFamily string         `json:"token_family"`
+	Scope       string         `json:"scope,omitempty"`
+	Audience    []string       `json:"audience,omitempty"`
+	JKT         string         `json:"jkt,omitempty"`
 }
 
 type TokenPair struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresAt    int64  `json:"expires_at"`
-	TokenType    string `json:"token_type"`
+	AccessToken  string         `json:"access_token"`
+	RefreshToken logging.Secret `json:"refresh_token"`
+	ExpiresAt    int64          `json:"expires_at"`
+	TokenType    string         `json:"token_type"`
+	Scope        string         `json:"scope,omitempty"`
+	TokenFamily  string         `json:"-"`
 }
 
 type Claims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	UserID    string        `json:"user_id"`
+	Username  string        `json:"username"`
+	Email     string        `json:"email"`
+	Scope     string        `json:"scope,omitempty"`
+	SessionID string        `json:"sid,omitempty"`
+	Act       *ActorClaims  `json:"act,omitempty"`
+	Cnf       *Confirmation `json:"cnf,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Confirmation is the RFC 7800 "cnf" claim; JKT binds the token to a DPoP key.
+type Confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// ActorClaims is the RFC 8693 "act" claim; nested Act records earlier delegations.
+type ActorClaims struct {
+	Subject string       `json:"sub"`
+	Act     *ActorClaims `json:"act,omitempty"`
+}
+
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	Scope    string `json:"scope"`
 }
 
 type RegisterRequest struct {
@@ -51,7 +88,8 @@ type RegisterRequest struct {
 }
 
 type RefreshRequest struct {
-	RefreshToken string `json:"refresh_token" binding:"required"`
+	RefreshToken logging.Secret `json:"refresh_token" binding:"required"`
+	Scope        string         `json:"scope"`
 }
 
 type TokenInfo struct {
@@ -66,6 +104,7 @@ type TokenDetails struct {
 	ExpiresAt time.Time `json:"expires_at"`
 	IsValid   bool      `json:"is_valid"`
 	Claims    *Claims   `json:"claims,omitempty"`
+	Scopes    []string  `json:"scopes,omitempty"`
 	Type      string    `json:"type"`
 }
 
@@ -77,39 +116,283 @@ type UserProfile struct {
 	LastLogin time.Time `json:"last_login,omitempty"`
 }
 
+// APIResponse is the envelope of every /api/v1 response. Error responses carry
+// the request ID so a failure reported by a client can be found in the logs.
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success   bool        `json:"success"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
 }
 
+const (
+	QRCodeTypeLogin       = "login"
+	QRCodeTypeDevice      = "device"
+	QRCodeTypeCrossDevice = "cross_device"
+	QRCodeTypeApproval    = "approval"
+
+	QRCodeStatusPending  = "pending"
+	QRCodeStatusScanned  = "scanned"
+	QRCodeStatusApproved = "approved"
+	QRCodeStatusDenied   = "denied"
+	QRCodeStatusUsed     = "used"
+	QRCodeStatusExpired  = "expired"
+	QRCodeStatusRevoked  = "revoked"
+)
+
 type QRCode struct {
+	ID           string     `json:"id"`
+	Type         string     `json:"type"`
+	Data         string     `json:"data" log:"secret"`
+	UserID       string     `json:"user_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	IsUsed       bool       `json:"is_used"`
+	UsedAt       *time.Time `json:"used_at,omitempty"`
+	IPAddress    string     `json:"ip_address,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	UserCode     string     `json:"user_code,omitempty"`
+	ClientID     string     `json:"client_id,omitempty"`
+	Scope        string     `json:"scope,omitempty"`
+	Interval     int        `json:"interval,omitempty"`
+	LastPolledAt *time.Time `json:"last_polled_at,omitempty"`
+	// Cross-device login: the unauthenticated device that displays the code and
+	// the secret it uses to collect its tokens once the phone approves.
+	DeviceName     string `json:"device_name,omitempty"`
+	RequesterIP    string `json:"requester_ip,omitempty"`
+	RequesterAgent string `json:"requester_agent,omitempty"`
+	ScannedBy      string `json:"scanned_by,omitempty"`
+	PollTokenHash  string `json:"-"`
+	// Transaction approval: the sensitive action the signed-in session wants
+	// confirmed, and that session, which may not confirm it itself.
+	Action             string `json:"action,omitempty"`
+	RequesterSessionID string `json:"-"`
+	// Optional redemption policy for login codes and the attempts that broke it.
+	Binding           *QRBindingPolicy     `json:"binding,omitempty"`
+	BindingViolations []QRBindingViolation `json:"binding_violations,omitempty"`
+}
+
+// QRBindingPolicy restricts where a login QR code may be redeemed. SameNetwork
+// requires the generator's /24 (IPv4) or /64 (IPv6); AllowedIPs lists addresses
+// or CIDR prefixes; DeviceFingerprint must match the X-Device-Fingerprint header
+// and is stored hashed.
+type QRBindingPolicy struct {
+	SameNetwork       bool     `json:"same_network,omitempty"`
+	GeneratorIP       string   `json:"generator_ip,omitempty"`
+	AllowedIPs        []string `json:"allowed_ips,omitempty"`
+	DeviceFingerprint string   `json:"device_fingerprint,omitempty"`
+}
+
+// QRBindingViolation is a security event recorded when a redemption attempt did
+// not satisfy the code's binding policy.
+type QRBindingViolation struct {
+	Reason    string    `json:"reason"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ClientInfo describes the client making a request, as seen by the API layer.
+type ClientInfo struct {
+	IPAddress         string
+	UserAgent         string
+	DeviceFingerprint string
+}
+
+// QRCodeEvent is a state transition of a QR code, streamed to waiting clients.
+type QRCodeEvent struct {
+	QRCodeID  string    `json:"id"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type QRCodeRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
+type QRCodeGenerateRequest struct {
+	TTLSeconds int              `json:"ttl_seconds"`
+	Binding    *QRBindingPolicy `json:"binding"`
+	// UserCode also issues a typeable code for devices without a camera.
+	UserCode bool `json:"user_code"`
+}
+
+type QRUserCodeRequest struct {
+	UserCode string `json:"user_code" binding:"required"`
+	Scope    string `json:"scope"`
+}
+
+// QRCodeSummary is what an owner sees when listing their QR codes; the payload
+// itself is deliberately left out.
+type QRCodeSummary struct {
 	ID        string     `json:"id"`
-	Data      string     `json:"data"`
-	UserID    string     `json:"user_id"`
+	Status    string     `json:"status"`
 	CreatedAt time.Time  `json:"created_at"`
 	ExpiresAt time.Time  `json:"expires_at"`
-	IsUsed    bool       `json:"is_used"`
 	UsedAt    *time.Time `json:"used_at,omitempty"`
 	IPAddress string     `json:"ip_address,omitempty"`
 }
 
-type QRCodeRequest struct {
-	UserID string `json:"user_id" binding:"required"`
+type QRCodeValidationRequest struct {
+	QRData string `json:"qr_data" binding:"required"`
+	Scope  string `json:"scope"`
 }
 
-type QRCodeValidationRequest struct {
+type QRLoginStartRequest struct {
+	DeviceName string `json:"device_name"`
+	Scope      string `json:"scope"`
+}
+
+type QRLoginStartResponse struct {
+	ID        string         `json:"id"`
+	QRData    string         `json:"qr_data"`
+	PollToken logging.Secret `json:"poll_token"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+type QRLoginScanRequest struct {
 	QRData string `json:"qr_data" binding:"required"`
 }
 
+type QRLoginDecisionRequest struct {
+	QRData  string `json:"qr_data" binding:"required"`
+	Approve bool   `json:"approve"`
+}
+
+type QRLoginTokenRequest struct {
+	ID        string         `json:"id" binding:"required"`
+	PollToken logging.Secret `json:"poll_token" binding:"required"`
+}
+
+// QRLoginDevice describes the device asking to be signed in, shown on the phone before approval.
+type QRLoginDevice struct {
+	ID         string    `json:"id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type ApprovalRequest struct {
+	Action string `json:"action" binding:"required"`
+}
+
+type ApprovalStartResponse struct {
+	ID        string         `json:"id"`
+	QRData    string         `json:"qr_data"`
+	PollToken logging.Secret `json:"poll_token"`
+	Action    string         `json:"action"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+type ApprovalScanRequest struct {
+	QRData string `json:"qr_data" binding:"required"`
+}
+
+type ApprovalDecisionRequest struct {
+	QRData  string `json:"qr_data" binding:"required"`
+	Approve bool   `json:"approve"`
+}
+
+// ApprovalDetails is shown on the trusted phone before it approves an action.
+type ApprovalDetails struct {
+	ID        string    `json:"id"`
+	Action    string    `json:"action"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ApprovalTokenResponse struct {
+	ApprovalToken string    `json:"approval_token"`
+	Action        string    `json:"action"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// ApprovalClaims authorise exactly one execution of Action by UserID. The jti is
+// the approval QR code's ID, which is consumed when the token is redeemed.
+type ApprovalClaims struct {
+	UserID string `json:"user_id"`
+	Action string `json:"action"`
+	jwt.RegisteredClaims
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
 type QRCodeResponse struct {
 	ID        string    `json:"id"`
 	QRData    string    `json:"qr_data"`
+	UserCode  string    `json:"user_code,omitempty"`
 	ExpiresAt time.Time `json:"expires_at"`
 	Message   string    `json:"message"`
 }
 
+const (
+	AuditRegister           = "register"
+	AuditLogin              = "login"
+	AuditRefresh            = "refresh"
+	AuditTokenReuseDetected = "token_reuse_detected"
+	AuditFamilyRevoked      = "token_family_revoked"
+	AuditLogout             = "logout"
+	AuditTokenExchange      = "token_exchange"
+	AuditPasswordChange     = "password_change"
+	AuditAccountDelete      = "account_delete"
+	AuditQRGenerate         = "qr_generate"
+	AuditQRRedeem           = "qr_redeem"
+	AuditQRRevoke           = "qr_revoke"
+	AuditQRBindingViolation = "qr_binding_violation"
+	AuditUserCodeLocked     = "user_code_locked"
+	AuditDeviceAuthorize    = "device_authorize"
+	AuditDeviceVerify       = "device_verify"
+	AuditDeviceToken        = "device_token"
+	AuditQRLoginStart       = "qr_login_start"
+	AuditQRLoginDecide      = "qr_login_decide"
+	AuditQRLoginClaim       = "qr_login_claim"
+	AuditApprovalRequest    = "approval_request"
+	AuditApprovalDecide     = "approval_decide"
+	AuditApprovalRedeem     = "approval_redeem"
+	AuditConfigReload       = "config_reload"
+
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditEvent is one entry of the authentication audit trail. ActorID is the user
+// the event concerns, SessionID the refresh token family where one exists and
+// Subject the QR code, client or action involved. RequestID is the X-Request-ID
+// of the HTTP request that caused the event. Secrets are never recorded.
+type AuditEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	ActorID   string    `json:"actor_id,omitempty"`
+	SessionID string    `json:"session_id,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// AuditFilter selects audit events; zero fields match everything.
+type AuditFilter struct {
+	ActorID   string
+	RequestID string
+	Type      string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
 type DatabaseView struct {
 	Users     []*User         `json:"users"`
 	Tokens    []*RefreshToken `json:"tokens"`
@@ -126,4 +409,62 @@ type DatabaseStats struct {
 	ActiveQRCodes  int `json:"active_qr_codes"`
 	UsedQRCodes    int `json:"used_qr_codes"`
 	ExpiredQRCodes int `json:"expired_qr_codes"`
+	// QR redemption attempts rejected by a binding policy.
+	BindingViolations int `json:"binding_violations"`
+}
+
+type DeviceAuthorizationRequest struct {
+	ClientID string `form:"client_id" json:"client_id" binding:"required"`
+	Scope    string `form:"scope" json:"scope"`
+}
+
+type DeviceAuthorizationResponse struct {
+	DeviceCode              logging.Secret `json:"device_code"`
+	UserCode                string         `json:"user_code"`
+	VerificationURI         string         `json:"verification_uri"`
+	VerificationURIComplete string         `json:"verification_uri_complete"`
+	ExpiresIn               int            `json:"expires_in"`
+	Interval                int            `json:"interval"`
+}
+
+type DeviceVerificationRequest struct {
+	UserCode string `json:"user_code" binding:"required"`
+	Approve  bool   `json:"approve"`
+}
+
+type OAuthTokenRequest struct {
+	GrantType          string         `form:"grant_type" json:"grant_type" binding:"required"`
+	ClientID           string         `form:"client_id" json:"client_id"`
+	ClientSecret       string         `form:"client_secret" json:"client_secret"`
+	DeviceCode         logging.Secret `form:"device_code" json:"device_code"`
+	SubjectToken       string         `form:"subject_token" json:"subject_token"`
+	SubjectTokenType   string         `form:"subject_token_type" json:"subject_token_type"`
+	ActorToken         string         `form:"actor_token" json:"actor_token"`
+	ActorTokenType     string         `form:"actor_token_type" json:"actor_token_type"`
+	RequestedTokenType string         `form:"requested_token_type" json:"requested_token_type"`
+	Audience           string         `form:"audience" json:"audience"`
+	Resource           string         `form:"resource" json:"resource"`
+	Scope              string         `form:"scope" json:"scope"`
+}
+
+type OAuthTokenResponse struct {
+	AccessToken  string         `json:"access_token"`
+	TokenType    string         `json:"token_type"`
+	ExpiresIn    int64          `json:"expires_in"`
+	RefreshToken logging.Secret `json:"refresh_token,omitempty"`
+	Scope        string         `json:"scope,omitempty"`
+}
+
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+	RequestID        string `json:"request_id,omitempty"`
 }
@@ -0,0 +1,230 @@
+// Package qrimage renders QR codes as PNG or SVG without any native dependencies.
+package qrimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+
+	DefaultSize      = 256
+	MinSize          = 64
+	MaxSize          = 2048
+	DefaultQuietZone = 4
+	MaxQuietZone     = 16
+)
+
+var (
+	ErrInvalidFormat    = errors.New("format must be png or svg")
+	ErrInvalidSize      = fmt.Errorf("size must be between %d and %d", MinSize, MaxSize)
+	ErrInvalidLevel     = errors.New("ecc must be one of L, M, Q, H")
+	ErrInvalidQuietZone = fmt.Errorf("quiet zone must be between 0 and %d modules", MaxQuietZone)
+)
+
+// logoFraction is the share of the symbol width covered by an embedded logo. At
+// level H up to 30% of codewords can be recovered, so covering ~4% of the area
+// in the centre leaves plenty of margin.
+const logoFraction = 5
+
+type Options struct {
+	Format    string
+	Size      int
+	Level     qrcode.RecoveryLevel
+	QuietZone int
+	Logo      image.Image
+}
+
+// ParseLevel maps the conventional L/M/Q/H letters to a recovery level.
+func ParseLevel(level string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToUpper(level) {
+	case "L":
+		return qrcode.Low, nil
+	case "", "M":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	}
+	return 0, ErrInvalidLevel
+}
+
+func (o *Options) Validate() error {
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		return ErrInvalidFormat
+	}
+	if o.Size < MinSize || o.Size > MaxSize {
+		return ErrInvalidSize
+	}
+	if o.QuietZone < 0 || o.QuietZone > MaxQuietZone {
+		return ErrInvalidQuietZone
+	}
+	return nil
+}
+
+// ContentType returns the MIME type of the rendered output.
+func (o *Options) ContentType() string {
+	if o.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Render encodes content and draws it in the requested format. An embedded logo
+// forces the highest error correction level so the code still scans.
+func Render(content string, opts Options) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	level := opts.Level
+	if opts.Logo != nil {
+		level = qrcode.Highest
+	}
+
+	qr, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	qr.DisableBorder = true
+	modules := qr.Bitmap()
+
+	if opts.Format == FormatSVG {
+		return renderSVG(modules, opts)
+	}
+	return renderPNG(modules, opts)
+}
+
+// layout computes the pixel scale of one module and the offset that centres the
+// symbol (with its quiet zone) inside a size x size canvas.
+func layout(moduleCount int, opts Options) (scale, offset int) {
+	total := moduleCount + 2*opts.QuietZone
+	scale = opts.Size / total
+	if scale < 1 {
+		scale = 1
+	}
+	offset = (opts.Size-total*scale)/2 + opts.QuietZone*scale
+	return scale, offset
+}
+
+func renderPNG(modules [][]bool, opts Options) ([]byte, error) {
+	n := len(modules)
+	scale, offset := layout(n, opts)
+	size := opts.Size
+	if minimum := (n + 2*opts.QuietZone) * scale; minimum > size {
+		size = minimum
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			rect := image.Rect(offset+x*scale, offset+y*scale, offset+(x+1)*scale, offset+(y+1)*scale)
+			draw.Draw(canvas, rect, image.Black, image.Point{}, draw.Src)
+		}
+	}
+
+	if opts.Logo != nil {
+		symbol := n * scale
+		logoSize := symbol / logoFraction
+		pad := scale
+		start := offset + (symbol-logoSize)/2
+		draw.Draw(canvas, image.Rect(start-pad, start-pad, start+logoSize+pad, start+logoSize+pad), image.White, image.Point{}, draw.Src)
+		drawScaled(canvas, image.Rect(start, start, start+logoSize, start+logoSize), opts.Logo)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderSVG(modules [][]bool, opts Options) ([]byte, error) {
+	n := len(modules)
+	total := n + 2*opts.QuietZone
+	qz := opts.QuietZone
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, opts.Size, opts.Size, total, total)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, total, total)
+
+	// One horizontal run per path segment keeps the output small.
+	for y, row := range modules {
+		for x := 0; x < n; {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < n && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", start+qz, y+qz, x-start, x-start)
+		}
+	}
+	buf.WriteString(`"/>`)
+
+	if opts.Logo != nil {
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, opts.Logo); err != nil {
+			return nil, err
+		}
+		logoSize := float64(n) / logoFraction
+		start := float64(qz) + (float64(n)-logoSize)/2
+		fmt.Fprintf(&buf, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="#fff"/>`, start-1, start-1, logoSize+2, logoSize+2)
+		fmt.Fprintf(&buf, `<image x="%.2f" y="%.2f" width="%.2f" height="%.2f" href="data:image/png;base64,%s"/>`,
+			start, start, logoSize, logoSize, base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+
+	buf.WriteString(`</svg>`)
+	return buf.Bytes(), nil
+}
+
+// drawScaled draws src into dst's rect using nearest-neighbour sampling, which is
+// adequate for small logos and avoids pulling in an image scaling library.
+func drawScaled(dst draw.Image, rect image.Rectangle, src image.Image) {
+	sb := src.Bounds()
+	if sb.Empty() || rect.Empty() {
+		return
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		sy := sb.Min.Y + (y-rect.Min.Y)*sb.Dy()/rect.Dy()
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			sx := sb.Min.X + (x-rect.Min.X)*sb.Dx()/rect.Dx()
+			c := color.NRGBAModel.Convert(src.At(sx, sy)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			dst.Set(x, y, blend(dst.At(x, y), c))
+		}
+	}
+}
+
+func blend(bg color.Color, fg color.NRGBA) color.Color {
+	if fg.A == 0xff {
+		return fg
+	}
+	r, g, b, _ := bg.RGBA()
+	a := uint32(fg.A)
+	mix := func(f uint8, b uint32) uint8 {
+		return uint8((uint32(f)*a + (b>>8)*(0xff-a)) / 0xff)
+	}
+	return color.RGBA{R: mix(fg.R, r), G: mix(fg.G, g), B: mix(fg.B, b), A: 0xff}
+}
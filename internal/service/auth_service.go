@@ -1,12 +1,17 @@
 package service
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"log/slog"
+	"rotate-token-demo/internal/clock"
 	"rotate-token-demo/internal/config"
+	"rotate-token-demo/internal/logging"
 	"rotate-token-demo/internal/models"
 	"rotate-token-demo/internal/storage"
+	"rotate-token-demo/internal/tracing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -20,31 +25,49 @@ var (
 	ErrTokenExpired       = errors.New("token expired")
 	ErrTokenRevoked       = errors.New("token revoked for security reasons")
 	ErrUserExists         = errors.New("user already exists")
+	ErrSigningKeyMissing  = errors.New("signing key is not configured")
 )
 
 type AuthService struct {
-	userStorage  storage.UserStorage
-	tokenStorage storage.TokenStorage
-	config       *config.Config
+	userStorage       storage.UserStorage
+	tokenStorage      storage.TokenStorage
+	dpopReplayStorage storage.DPoPReplayStorage
+	auditLog          storage.AuditLog
+	config            *config.Live
+	clock             clock.Clock
 }
 
-func NewAuthService(userStorage storage.UserStorage, tokenStorage storage.TokenStorage, config *config.Config) *AuthService {
+func NewAuthService(userStorage storage.UserStorage, tokenStorage storage.TokenStorage, dpopReplayStorage storage.DPoPReplayStorage, auditLog storage.AuditLog, config *config.Live, clock clock.Clock) *AuthService {
 	return &AuthService{
-		userStorage:  userStorage,
-		tokenStorage: tokenStorage,
-		config:       config,
+		userStorage:       userStorage,
+		tokenStorage:      tokenStorage,
+		dpopReplayStorage: dpopReplayStorage,
+		auditLog:          auditLog,
+		config:            config,
+		clock:             clock,
 	}
 }
 
-func (s *AuthService) Register(req *models.RegisterRequest) (*models.User, error) {
-	if _, err := s.userStorage.GetUserByUsername(req.Username); err == nil {
-		return nil, ErrUserExists
+// cfg returns the current configuration. Callers that read several settings
+// should call it once, so they see a consistent snapshot across a reload.
+func (s *AuthService) cfg() *config.Config {
+	return s.config.Get()
+}
+
+func (s *AuthService) Register(ctx context.Context, req *models.RegisterRequest, client models.ClientInfo) (*models.User, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Register")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditRegister, Subject: req.Username}
+
+	if _, err := s.userStorage.GetUserByUsername(ctx, req.Username); err == nil {
+		return nil, s.auditFailure(ctx, client, event, ErrUserExists)
 	}
-	if _, err := s.userStorage.GetUserByEmail(req.Email); err == nil {
-		return nil, ErrUserExists
+	if _, err := s.userStorage.GetUserByEmail(ctx, req.Email); err == nil {
+		return nil, s.auditFailure(ctx, client, event, ErrUserExists)
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(ctx, req.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -54,106 +77,250 @@ func (s *AuthService) Register(req *models.RegisterRequest) (*models.User, error
 		Username: req.Username,
 		Email:    req.Email,
 		Password: string(hashedPassword),
-		CreateAt: time.Now(),
+		CreateAt: s.clock.Now(),
 	}
 
-	if err := s.userStorage.CreateUser(user); err != nil {
-		return nil, err
+	if err := s.userStorage.CreateUser(ctx, user); err != nil {
+		return nil, s.auditFailure(ctx, client, event, err)
 	}
 
+	event.ActorID = user.ID
+	s.audit(ctx, client, event)
 	return user, nil
 }
 
-func (s *AuthService) Login(req *models.LoginRequest) (*models.TokenPair, error) {
-	user, err := s.userStorage.GetUserByUsername(req.Username)
+// Login issues a new token family. A non-empty dpopJKT binds the family to that DPoP key.
+func (s *AuthService) Login(ctx context.Context, req *models.LoginRequest, client models.ClientInfo, dpopJKT string) (*models.TokenPair, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Login")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditLogin, Subject: req.Username}
+
+	user, err := s.userStorage.GetUserByUsername(ctx, req.Username)
 	if err != nil {
-		return nil, ErrInvalidCredentials
+		event.Reason = "unknown_user"
+		return nil, s.auditFailure(ctx, client, event, ErrInvalidCredentials)
 	}
+	event.ActorID = user.ID
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return nil, ErrInvalidCredentials
+	if err := comparePassword(ctx, user.Password, req.Password); err != nil {
+		event.Reason = "wrong_password"
+		return nil, s.auditFailure(ctx, client, event, ErrInvalidCredentials)
 	}
 
-	if err := s.userStorage.UpdateLastLogin(user.ID); err != nil {
-		// Log error but don't fail login
-		// In production, you might want to use a proper logger
+	cfg := s.cfg()
+	scope, err := resolveScope(req.Scope, cfg.DefaultScopes, grantableScopes(user, cfg.SupportedScopes))
+	if err != nil {
+		return nil, s.auditFailure(ctx, client, event, err)
+	}
+
+	if err := s.userStorage.UpdateLastLogin(ctx, user.ID); err != nil {
+		// A stale last-login timestamp is not worth failing the login over.
+		slog.WarnContext(ctx, "failed to update last login", "user_id", user.ID, "error", err)
+	}
+
+	tokenPair, err := s.generateTokenPair(ctx, user, tokenGrant{
+		scope:    scope,
+		audience: []string{s.cfg().AccessTokenAudience},
+		jkt:      dpopJKT,
+	})
+	if err != nil {
+		return nil, s.auditFailure(ctx, client, event, err)
 	}
 
-	return s.generateTokenPair(user)
+	event.SessionID = tokenPair.TokenFamily
+	s.audit(ctx, client, event)
+	return tokenPair, nil
 }
 
-func (s *AuthService) RefreshToken(req *models.RefreshRequest) (*models.TokenPair, error) {
-	refreshToken, err := s.tokenStorage.GetRefreshToken(req.RefreshToken)
+func (s *AuthService) RefreshToken(ctx context.Context, req *models.RefreshRequest, client models.ClientInfo, dpopJKT string) (*models.TokenPair, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.RefreshToken")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditRefresh}
+
+	refreshToken, err := s.tokenStorage.GetRefreshToken(ctx, string(req.RefreshToken))
 	if err != nil {
 		// Bu refresh token veritabanında yoksa ya da daha önce iptal edildiyse,
 		// bunu olası bir "replay" girişimi olarak değerlendirmeliyiz
 		// Güvenlik adına ilgili token ailesini (family) komple iptal etmeliyiz
 		if err == storage.ErrTokenNotFound || err == storage.ErrTokenRevoked {
+			if err == storage.ErrTokenRevoked {
+				event.Type = models.AuditTokenReuseDetected
+			}
 			// JWT içinden token family bilgisini çıkarmayı denemeliyiz; bulursak tüm aileyi iptal etmeliyiz
-			if tokenFamily := s.extractTokenFamilyFromToken(req.RefreshToken); tokenFamily != "" {
-				s.tokenStorage.RevokeTokenFamily(tokenFamily)
+			if tokenFamily := s.extractTokenFamilyFromToken(string(req.RefreshToken)); tokenFamily != "" {
+				s.revokeFamily(ctx, tokenFamily)
+				event.Type = models.AuditTokenReuseDetected
+				event.SessionID = tokenFamily
 			}
-			return nil, ErrTokenRevoked
+			return nil, s.auditFailure(ctx, client, event, ErrTokenRevoked)
 		}
 		if err == storage.ErrTokenExpired {
-			return nil, ErrTokenExpired
+			return nil, s.auditFailure(ctx, client, event, ErrTokenExpired)
 		}
-		return nil, ErrTokenInvalid
+		return nil, s.auditFailure(ctx, client, event, ErrTokenInvalid)
+	}
+	event.ActorID = refreshToken.UserID
+	event.SessionID = refreshToken.TokenFamily
+
+	// DPoP'ye bağlı bir aile, yalnızca aynı anahtarı kanıtlayan istemci tarafından yenilenebilir.
+	// Anahtarsız ya da farklı anahtarla gelen refresh, çalınmış token kabul edilip aile iptal edilmeli
+	if refreshToken.JKT != "" && refreshToken.JKT != dpopJKT {
+		s.revokeFamily(ctx, refreshToken.TokenFamily)
+		event.Type = models.AuditFamilyRevoked
+		return nil, s.auditFailure(ctx, client, event, ErrDPoPKeyMismatch)
 	}
 
-	user, err := s.userStorage.GetUserByID(refreshToken.UserID)
+	user, err := s.userStorage.GetUserByID(ctx, refreshToken.UserID)
 	if err != nil {
-		return nil, ErrTokenInvalid
+		return nil, s.auditFailure(ctx, client, event, ErrTokenInvalid)
+	}
+
+	// Yenilemede scope yalnızca daraltılabilir, asla genişletilemez
+	granted := ParseScope(refreshToken.Scope)
+	scope, err := resolveScope(req.Scope, granted, granted)
+	if err != nil {
+		return nil, s.auditFailure(ctx, client, event, err)
 	}
 
 	// Token rotation açıksa, mevcut refresh token'ı tekrar kullanılmaması için iptal etmeliyiz
-	if s.config.EnableTokenRotation {
-		if err := s.tokenStorage.RevokeRefreshToken(req.RefreshToken); err != nil {
-			return nil, err
+	if s.cfg().EnableTokenRotation {
+		if err := s.tokenStorage.RevokeRefreshToken(ctx, string(req.RefreshToken)); err != nil {
+			return nil, s.auditFailure(ctx, client, event, err)
 		}
 	}
 
 	// Aynı token ailesiyle yeni bir access/refresh çifti üretmeliyiz
-	tokenPair, err := s.generateTokenPairWithFamily(user, refreshToken.TokenFamily)
+	tokenPair, err := s.generateTokenPairWithFamily(ctx, user, refreshToken.TokenFamily, tokenGrant{
+		scope:    scope,
+		audience: refreshToken.Audience,
+		jkt:      refreshToken.JKT,
+	})
 	if err != nil {
 		// Üretim başarısız olursa ve hâlihazırda mevcut token'ı iptal ettiysek,
 		// güvenlik için tüm aileyi de iptal etmeliyiz
-		if s.config.EnableTokenRotation {
-			s.tokenStorage.RevokeTokenFamily(refreshToken.TokenFamily)
+		if s.cfg().EnableTokenRotation {
+			s.revokeFamily(ctx, refreshToken.TokenFamily)
 		}
-		return nil, err
+		return nil, s.auditFailure(ctx, client, event, err)
 	}
 
+	s.audit(ctx, client, event)
 	return tokenPair, nil
 }
 
-func (s *AuthService) Logout(userID string) error {
-	return s.tokenStorage.RevokeAllUserTokens(userID)
+// revokeFamily revokes a token family on a path that is already failing; an error
+// is logged rather than returned so it cannot mask the original failure.
+func (s *AuthService) revokeFamily(ctx context.Context, tokenFamily string) {
+	if err := s.tokenStorage.RevokeTokenFamily(ctx, tokenFamily); err != nil {
+		slog.ErrorContext(ctx, "failed to revoke token family", "session_id", tokenFamily, "error", err)
+	}
+}
+
+func (s *AuthService) Logout(ctx context.Context, userID string, client models.ClientInfo) error {
+	ctx, span := tracing.Start(ctx, "AuthService.Logout")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditLogout, ActorID: userID}
+
+	if err := s.tokenStorage.RevokeAllUserTokens(ctx, userID); err != nil {
+		return s.auditFailure(ctx, client, event, err)
+	}
+
+	s.audit(ctx, client, event)
+	return nil
+}
+
+// ChangePassword replaces the user's password and revokes every refresh token, so
+// all other sessions have to sign in again with the new password. The current
+// password must be given as well.
+func (s *AuthService) ChangePassword(ctx context.Context, userID, currentPassword, newPassword string, client models.ClientInfo) error {
+	ctx, span := tracing.Start(ctx, "AuthService.ChangePassword")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditPasswordChange, ActorID: userID}
+
+	user, err := s.userStorage.GetUserByID(ctx, userID)
+	if err != nil {
+		return s.auditFailure(ctx, client, event, err)
+	}
+
+	if err := comparePassword(ctx, user.Password, currentPassword); err != nil {
+		event.Reason = "wrong_password"
+		return s.auditFailure(ctx, client, event, ErrInvalidCredentials)
+	}
+
+	hashedPassword, err := hashPassword(ctx, newPassword)
+	if err != nil {
+		return s.auditFailure(ctx, client, event, err)
+	}
+
+	updated := *user
+	updated.Password = string(hashedPassword)
+	if err := s.userStorage.UpdateUser(ctx, &updated); err != nil {
+		return s.auditFailure(ctx, client, event, err)
+	}
+
+	if err := s.tokenStorage.RevokeAllUserTokens(ctx, userID); err != nil {
+		return s.auditFailure(ctx, client, event, err)
+	}
+
+	s.audit(ctx, client, event)
+	return nil
+}
+
+func (s *AuthService) DeleteAccount(ctx context.Context, userID string, client models.ClientInfo) error {
+	ctx, span := tracing.Start(ctx, "AuthService.DeleteAccount")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditAccountDelete, ActorID: userID}
+
+	if err := s.tokenStorage.RevokeAllUserTokens(ctx, userID); err != nil {
+		return s.auditFailure(ctx, client, event, err)
+	}
+
+	if err := s.userStorage.DeleteUser(ctx, userID); err != nil {
+		return s.auditFailure(ctx, client, event, err)
+	}
+
+	s.audit(ctx, client, event)
+	return nil
 }
 
-func (s *AuthService) ValidateAccessToken(tokenString string) (*models.Claims, error) {
+func (s *AuthService) ValidateAccessToken(ctx context.Context, tokenString string) (*models.Claims, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.ValidateAccessToken")
+	defer span.End()
+
+	cfg := s.cfg()
 	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.config.JWTSecret), nil
-	})
+		return verificationKeys(cfg), nil
+	}, jwt.WithTimeFunc(s.clock.Now))
 
 	if err != nil {
 		return nil, ErrTokenInvalid
 	}
 
 	if claims, ok := token.Claims.(*models.Claims); ok && token.Valid {
+		if !hasAnyAudience(claims.Audience, cfg.ExpectedAudiences) {
+			return nil, ErrTokenInvalid
+		}
 		return claims, nil
 	}
 
 	return nil, ErrTokenInvalid
 }
 
-func (s *AuthService) GetTokenInfo(accessToken, refreshToken string) (*models.TokenInfo, error) {
+func (s *AuthService) GetTokenInfo(ctx context.Context, accessToken, refreshToken string) (*models.TokenInfo, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.GetTokenInfo")
+	defer span.End()
+
 	info := &models.TokenInfo{
-		TokenRotation: s.config.EnableTokenRotation,
+		TokenRotation: s.cfg().EnableTokenRotation,
 	}
 
 	if accessToken != "" {
-		claims, err := s.ValidateAccessToken(accessToken)
+		claims, err := s.ValidateAccessToken(ctx, accessToken)
 		tokenDetails := &models.TokenDetails{
 			Token:   accessToken,
 			Type:    "access",
@@ -163,13 +330,14 @@ func (s *AuthService) GetTokenInfo(accessToken, refreshToken string) (*models.To
 		if err == nil {
 			tokenDetails.ExpiresAt = claims.ExpiresAt.Time
 			tokenDetails.Claims = claims
+			tokenDetails.Scopes = ParseScope(claims.Scope)
 		}
 
 		info.AccessToken = tokenDetails
 	}
 
 	if refreshToken != "" {
-		storedToken, err := s.tokenStorage.GetRefreshToken(refreshToken)
+		storedToken, err := s.tokenStorage.GetRefreshToken(ctx, refreshToken)
 		tokenDetails := &models.TokenDetails{
 			Token:   refreshToken,
 			Type:    "refresh",
@@ -178,6 +346,7 @@ func (s *AuthService) GetTokenInfo(accessToken, refreshToken string) (*models.To
 
 		if err == nil {
 			tokenDetails.ExpiresAt = storedToken.ExpiresAt
+			tokenDetails.Scopes = ParseScope(storedToken.Scope)
 			info.TokenFamily = storedToken.TokenFamily
 		}
 
@@ -187,8 +356,11 @@ func (s *AuthService) GetTokenInfo(accessToken, refreshToken string) (*models.To
 	return info, nil
 }
 
-func (s *AuthService) GetUserProfile(userID string) (*models.UserProfile, error) {
-	user, err := s.userStorage.GetUserByID(userID)
+func (s *AuthService) GetUserProfile(ctx context.Context, userID string) (*models.UserProfile, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.GetUserProfile")
+	defer span.End()
+
+	user, err := s.userStorage.GetUserByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -202,49 +374,76 @@ func (s *AuthService) GetUserProfile(userID string) (*models.UserProfile, error)
 	}, nil
 }
 
-func (s *AuthService) generateTokenPair(user *models.User) (*models.TokenPair, error) {
+// tokenGrant carries what a token family was granted; refreshes reuse it so the
+// family can only ever be narrowed.
+type tokenGrant struct {
+	scope    string
+	audience []string
+	jkt      string
+}
+
+func (s *AuthService) generateTokenPair(ctx context.Context, user *models.User, grant tokenGrant) (*models.TokenPair, error) {
 	tokenFamily := uuid.New().String()
-	return s.generateTokenPairWithFamily(user, tokenFamily)
+	return s.generateTokenPairWithFamily(ctx, user, tokenFamily, grant)
 }
 
-func (s *AuthService) generateTokenPairWithFamily(user *models.User, tokenFamily string) (*models.TokenPair, error) {
-	accessToken, expiresAt, err := s.generateAccessToken(user)
+func (s *AuthService) generateTokenPairWithFamily(ctx context.Context, user *models.User, tokenFamily string, grant tokenGrant) (*models.TokenPair, error) {
+	// Every flow checks the user's roles before getting here; this catches a
+	// role revoked since the family was issued.
+	if !userMayHold(user, grant.scope) {
+		return nil, ErrInvalidScope
+	}
+
+	accessToken, expiresAt, err := s.generateAccessToken(ctx, user, tokenFamily, grant)
 	if err != nil {
 		return nil, err
 	}
 
-	refreshTokenString, err := s.generateRefreshToken(user.ID, tokenFamily)
+	refreshTokenString, err := s.generateRefreshToken(ctx, user.ID, tokenFamily, grant)
 	if err != nil {
 		return nil, err
 	}
 
+	tokenType := "Bearer"
+	if grant.jkt != "" {
+		tokenType = "DPoP"
+	}
+
 	return &models.TokenPair{
 		AccessToken:  accessToken,
-		RefreshToken: refreshTokenString,
+		RefreshToken: logging.Secret(refreshTokenString),
 		ExpiresAt:    expiresAt.Unix(),
-		TokenType:    "Bearer",
+		TokenType:    tokenType,
+		Scope:        grant.scope,
+		TokenFamily:  tokenFamily,
 	}, nil
 }
 
-func (s *AuthService) generateAccessToken(user *models.User) (string, time.Time, error) {
-	expiresAt := time.Now().Add(s.config.AccessTokenExpiry)
+func (s *AuthService) generateAccessToken(ctx context.Context, user *models.User, tokenFamily string, grant tokenGrant) (string, time.Time, error) {
+	now := s.clock.Now()
+	expiresAt := now.Add(s.cfg().AccessTokenExpiry)
 
 	claims := &models.Claims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Email:    user.Email,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Scope:     grant.scope,
+		SessionID: tokenFamily,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "rotate-token-demo",
 			Subject:   user.ID,
+			Audience:  grant.audience,
 			ID:        uuid.New().String(),
 		},
 	}
+	if grant.jkt != "" {
+		claims.Cnf = &models.Confirmation{JKT: grant.jkt}
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.config.JWTSecret))
+	tokenString, err := s.signClaims(ctx, claims)
 	if err != nil {
 		return "", time.Time{}, err
 	}
@@ -252,24 +451,84 @@ func (s *AuthService) generateAccessToken(user *models.User) (string, time.Time,
 	return tokenString, expiresAt, nil
 }
 
-func (s *AuthService) generateRefreshToken(userID, tokenFamily string) (string, error) {
+func (s *AuthService) signClaims(ctx context.Context, claims jwt.Claims) (string, error) {
+	_, span := tracing.Start(ctx, "jwt.Sign")
+	defer span.End()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(s.cfg().JWTSecret))
+}
+
+// CheckSigningKey verifies that the key material is loaded by signing and
+// verifying a short-lived probe token. Used by the readiness probe.
+func (s *AuthService) CheckSigningKey(ctx context.Context) error {
+	if s.cfg().JWTSecret == "" {
+		return ErrSigningKeyMissing
+	}
+
+	probe := jwt.RegisteredClaims{
+		Subject:   "readiness-probe",
+		ExpiresAt: jwt.NewNumericDate(s.clock.Now().Add(time.Minute)),
+	}
+	tokenString, err := s.signClaims(ctx, probe)
+	if err != nil {
+		return err
+	}
+
+	_, err = jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.cfg().JWTSecret), nil
+	}, jwt.WithTimeFunc(s.clock.Now))
+	return err
+}
+
+// verificationKeys is the key ring tokens are verified against. Tokens signed
+// with a secret moved to jwt_previous_secrets stay valid until they expire.
+func verificationKeys(cfg *config.Config) jwt.VerificationKeySet {
+	var keys jwt.VerificationKeySet
+	for _, secret := range cfg.VerificationSecrets() {
+		keys.Keys = append(keys.Keys, []byte(secret))
+	}
+	return keys
+}
+
+// hashPassword and comparePassword get their own spans because bcrypt's cost
+// usually dominates the latency of the requests that use it.
+func hashPassword(ctx context.Context, password string) ([]byte, error) {
+	_, span := tracing.Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
+
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+func comparePassword(ctx context.Context, hash, password string) error {
+	_, span := tracing.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+func (s *AuthService) generateRefreshToken(ctx context.Context, userID, tokenFamily string, grant tokenGrant) (string, error) {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err
 	}
 	tokenString := base64.URLEncoding.EncodeToString(bytes)
 
+	now := s.clock.Now()
 	refreshToken := &models.RefreshToken{
 		ID:          uuid.New().String(),
 		UserID:      userID,
-		Token:       tokenString,
-		ExpiresAt:   time.Now().Add(s.config.RefreshTokenExpiry),
-		CreatedAt:   time.Now(),
+		Token:       logging.Secret(tokenString),
+		ExpiresAt:   now.Add(s.cfg().RefreshTokenExpiry),
+		CreatedAt:   now,
 		IsRevoked:   false,
 		TokenFamily: tokenFamily,
+		Scope:       grant.scope,
+		Audience:    grant.audience,
+		JKT:         grant.jkt,
 	}
 
-	if err := s.tokenStorage.StoreRefreshToken(refreshToken); err != nil {
+	if err := s.tokenStorage.StoreRefreshToken(ctx, refreshToken); err != nil {
 		return "", err
 	}
 
@@ -288,17 +547,54 @@ func (s *AuthService) extractTokenFamilyFromToken(tokenString string) string {
 	return ""
 }
 
-func (s *AuthService) RevokeTokenFamily(refreshToken string) error {
+// hasAnyAudience reports whether the token audience intersects the accepted list.
+func hasAnyAudience(audience jwt.ClaimStrings, accepted []string) bool {
+	for _, aud := range audience {
+		for _, a := range accepted {
+			if aud == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clientAudience returns the audiences configured for an OAuth client, falling back
+// to this API's own audience.
+func (s *AuthService) clientAudience(clientID string) []string {
+	if audience, ok := s.cfg().ClientAudiences[clientID]; ok && len(audience) > 0 {
+		return audience
+	}
+	return []string{s.cfg().AccessTokenAudience}
+}
+
+func (s *AuthService) RevokeTokenFamily(ctx context.Context, refreshToken string, client models.ClientInfo) error {
+	ctx, span := tracing.Start(ctx, "AuthService.RevokeTokenFamily")
+	defer span.End()
 
-	token, err := s.tokenStorage.GetRefreshToken(refreshToken)
+	event := models.AuditEvent{Type: models.AuditFamilyRevoked, Reason: "manual_revocation"}
+
+	token, err := s.tokenStorage.GetRefreshToken(ctx, refreshToken)
 	if err != nil {
-		return err
+		event.Reason = ""
+		return s.auditFailure(ctx, client, event, err)
+	}
+	event.ActorID = token.UserID
+	event.SessionID = token.TokenFamily
+
+	if err := s.tokenStorage.RevokeTokenFamily(ctx, token.TokenFamily); err != nil {
+		return s.auditFailure(ctx, client, event, err)
 	}
-	return s.tokenStorage.RevokeTokenFamily(token.TokenFamily)
+
+	s.audit(ctx, client, event)
+	return nil
 }
 
-func (s *AuthService) GetTokenStatus(refreshToken string) (map[string]interface{}, error) {
-	token, err := s.tokenStorage.GetRefreshToken(refreshToken)
+func (s *AuthService) GetTokenStatus(ctx context.Context, refreshToken string) (map[string]interface{}, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.GetTokenStatus")
+	defer span.End()
+
+	token, err := s.tokenStorage.GetRefreshToken(ctx, refreshToken)
 	if err != nil {
 		return map[string]interface{}{
 			"valid": false,
@@ -306,14 +602,16 @@ func (s *AuthService) GetTokenStatus(refreshToken string) (map[string]interface{
 		}, nil
 	}
 
+	now := s.clock.Now()
 	status := map[string]interface{}{
-		"valid":        !token.IsRevoked && token.ExpiresAt.After(time.Now()),
+		"valid":        !token.IsRevoked && token.ExpiresAt.After(now),
 		"revoked":      token.IsRevoked,
-		"expired":      token.ExpiresAt.Before(time.Now()),
+		"expired":      token.ExpiresAt.Before(now),
 		"expires_at":   token.ExpiresAt,
 		"created_at":   token.CreatedAt,
 		"token_family": token.TokenFamily,
 		"user_id":      token.UserID,
+		"scope":        token.Scope,
 	}
 
 	return status, nil
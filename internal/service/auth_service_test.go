@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"rotate-token-demo/internal/clock/clocktest"
+	"rotate-token-demo/internal/config"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/storage"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+var testNow = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestAuthService(t *testing.T) (*AuthService, *clocktest.Fake) {
+	t.Helper()
+	return newTestAuthServiceWithConfig(t, config.NewLive(config.New(), nil))
+}
+
+func newTestAuthServiceWithConfig(t *testing.T, live *config.Live) (*AuthService, *clocktest.Fake) {
+	t.Helper()
+
+	clk := clocktest.NewFake(testNow)
+	tokens := storage.NewInMemoryTokenStorage(clk)
+	dpopReplay := storage.NewInMemoryDPoPReplayStorage(clk)
+	t.Cleanup(func() {
+		tokens.Close()
+		dpopReplay.Close()
+	})
+
+	s := NewAuthService(storage.NewInMemoryUserStorage(clk), tokens, dpopReplay, storage.NewInMemoryAuditLog(100), live, clk)
+	return s, clk
+}
+
+func loginTestUser(t *testing.T, s *AuthService) *models.TokenPair {
+	t.Helper()
+
+	ctx := context.Background()
+	if _, err := s.Register(ctx, &models.RegisterRequest{
+		Username: "alice",
+		Email:    "alice@example.com",
+		Password: "password123",
+	}, models.ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := s.Login(ctx, &models.LoginRequest{Username: "alice", Password: "password123"}, models.ClientInfo{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s, clk := newTestAuthService(t)
+	ctx := context.Background()
+	pair := loginTestUser(t, s)
+
+	// The access token lapses after two minutes; the refresh token still works.
+	clk.Advance(3 * time.Minute)
+	if _, err := s.ValidateAccessToken(ctx, pair.AccessToken); err != ErrTokenInvalid {
+		t.Errorf("expired access token: got %v, want %v", err, ErrTokenInvalid)
+	}
+
+	rotated, err := s.RefreshToken(ctx, &models.RefreshRequest{RefreshToken: pair.RefreshToken}, models.ClientInfo{}, "")
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if rotated.RefreshToken == pair.RefreshToken {
+		t.Error("refresh returned the same refresh token")
+	}
+	if _, err := s.ValidateAccessToken(ctx, rotated.AccessToken); err != nil {
+		t.Errorf("rotated access token: %v", err)
+	}
+
+	if _, err := s.RefreshToken(ctx, &models.RefreshRequest{RefreshToken: pair.RefreshToken}, models.ClientInfo{}, ""); err != ErrTokenRevoked {
+		t.Errorf("reused refresh token: got %v, want %v", err, ErrTokenRevoked)
+	}
+}
+
+func TestRefreshTokenExpires(t *testing.T) {
+	s, clk := newTestAuthService(t)
+	ctx := context.Background()
+	pair := loginTestUser(t, s)
+
+	clk.Advance(31 * time.Minute)
+	if _, err := s.RefreshToken(ctx, &models.RefreshRequest{RefreshToken: pair.RefreshToken}, models.ClientInfo{}, ""); err != ErrTokenExpired {
+		t.Errorf("expired refresh token: got %v, want %v", err, ErrTokenExpired)
+	}
+}
+
+func TestAuditTruncatesClientFields(t *testing.T) {
+	s, _ := newTestAuthService(t)
+	ctx := context.Background()
+
+	client := models.ClientInfo{IPAddress: "192.0.2.1", UserAgent: strings.Repeat("é", 1000)}
+	if _, err := s.Login(ctx, &models.LoginRequest{Username: "nobody", Password: "wrong"}, client, ""); err == nil {
+		t.Fatal("login of unknown user succeeded")
+	}
+
+	events, err := s.AuditLog(ctx, models.AuditFilter{})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("audit log: got %d events, %v", len(events), err)
+	}
+	if ua := events[0].UserAgent; len(ua) > maxAuditUserAgent || !utf8.ValidString(ua) || ua == "" {
+		t.Errorf("user agent recorded as %d bytes (valid UTF-8: %v)", len(ua), utf8.ValidString(ua))
+	}
+}
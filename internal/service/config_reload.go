@@ -0,0 +1,32 @@
+package service
+
+import (
+	"context"
+	"rotate-token-demo/internal/config"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/tracing"
+	"strings"
+)
+
+// ReloadConfig re-reads the configuration and swaps in the reloadable settings.
+// It is triggered by SIGHUP (with an empty actorID) or by an admin. The audit
+// event's subject lists the applied settings; settings that only take effect on
+// restart are noted in its reason.
+func (s *AuthService) ReloadConfig(ctx context.Context, actorID string, client models.ClientInfo) (*config.ReloadResult, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.ReloadConfig")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditConfigReload, ActorID: actorID}
+
+	result, err := s.config.Reload()
+	if err != nil {
+		return nil, s.auditFailure(ctx, client, event, err)
+	}
+
+	event.Subject = strings.Join(result.Applied, ",")
+	if len(result.RestartRequired) > 0 {
+		event.Reason = "restart required: " + strings.Join(result.RestartRequired, ",")
+	}
+	s.audit(ctx, client, event)
+	return result, nil
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"rotate-token-demo/internal/logging"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/storage"
+	"rotate-token-demo/internal/tracing"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RFC 8628 section 3.5 error codes returned while the device polls the token endpoint.
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredDeviceCode    = errors.New("expired_token")
+	ErrInvalidGrant         = errors.New("invalid_grant")
+	ErrInvalidUserCode      = errors.New("invalid or expired user code")
+)
+
+// userCodeAlphabet avoids vowels and look-alike characters, as recommended by RFC 8628 section 6.1.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+const slowDownIncrement = 5
+
+func (s *QRCodeService) StartDeviceAuthorization(ctx context.Context, req *models.DeviceAuthorizationRequest, client models.ClientInfo) (*models.DeviceAuthorizationResponse, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.StartDeviceAuthorization")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditDeviceAuthorize, Subject: req.ClientID}
+
+	cfg := s.authService.cfg()
+
+	scope, err := resolveScope(req.Scope, cfg.DefaultScopes, cfg.SupportedScopes)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
+	}
+
+	deviceCode, err := randomToken()
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrQRCodeGenerationFailed)
+	}
+
+	now := s.authService.clock.Now()
+	interval := int(cfg.DevicePollInterval / time.Second)
+
+	qrCode := &models.QRCode{
+		ID:        uuid.New().String(),
+		Type:      models.QRCodeTypeDevice,
+		Data:      deviceCode,
+		CreatedAt: now,
+		ExpiresAt: now.Add(cfg.DeviceCodeExpiry),
+		Status:    models.QRCodeStatusPending,
+		ClientID:  req.ClientID,
+		Scope:     scope,
+		Interval:  interval,
+	}
+
+	if err := s.createWithUserCode(ctx, qrCode); err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, fmt.Errorf("failed to store device code: %w", err))
+	}
+
+	s.authService.audit(ctx, client, event)
+	return &models.DeviceAuthorizationResponse{
+		DeviceCode:              logging.Secret(deviceCode),
+		UserCode:                qrCode.UserCode,
+		VerificationURI:         cfg.VerificationURI,
+		VerificationURIComplete: cfg.VerificationURI + "?user_code=" + url.QueryEscape(qrCode.UserCode),
+		ExpiresIn:               int(cfg.DeviceCodeExpiry / time.Second),
+		Interval:                interval,
+	}, nil
+}
+
+// VerifyDeviceCode records the signed-in user's decision for a pending device authorization.
+func (s *QRCodeService) VerifyDeviceCode(ctx context.Context, userID, userCode string, approve bool, client models.ClientInfo) (*models.QRCode, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.VerifyDeviceCode")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditDeviceVerify, ActorID: userID}
+
+	attemptKey := "user:" + userID
+	if s.isLocked(ctx, attemptKey) {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrUserCodeLocked)
+	}
+
+	qrCode, err := s.qrStorage.GetQRCodeByUserCode(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, s.userCodeFailure(ctx, client, attemptKey, ErrInvalidUserCode))
+	}
+
+	if qrCode.Type != models.QRCodeTypeDevice || qrCode.Status != models.QRCodeStatusPending {
+		return nil, s.authService.auditFailure(ctx, client, event, s.userCodeFailure(ctx, client, attemptKey, ErrInvalidUserCode))
+	}
+
+	if s.authService.clock.Now().After(qrCode.ExpiresAt) {
+		return nil, s.authService.auditFailure(ctx, client, event, s.userCodeFailure(ctx, client, attemptKey, ErrInvalidUserCode))
+	}
+	event.Subject = qrCode.ClientID
+
+	if approve {
+		if err := s.checkUserScope(ctx, userID, qrCode.Scope); err != nil {
+			return nil, s.authService.auditFailure(ctx, client, event, err)
+		}
+	}
+
+	transition := storage.QRCodeTransition{From: models.QRCodeStatusPending, To: models.QRCodeStatusDenied}
+	if approve {
+		transition.To = models.QRCodeStatusApproved
+		transition.UserID = userID
+	}
+
+	updated, err := s.qrStorage.TransitionQRCode(ctx, qrCode.ID, transition)
+	if err != nil {
+		switch err {
+		case storage.ErrQRCodeNotFound, storage.ErrQRCodeExpired, storage.ErrQRCodeUsed, storage.ErrQRCodeStateChanged:
+			return nil, s.authService.auditFailure(ctx, client, event, ErrInvalidUserCode)
+		}
+		return nil, s.authService.auditFailure(ctx, client, event, fmt.Errorf("failed to update device code: %w", err))
+	}
+
+	if !approve {
+		event.Reason = "denied"
+	}
+	s.authService.audit(ctx, client, event)
+	return updated, nil
+}
+
+// PollDeviceToken implements the device_code grant of the token endpoint.
+func (s *QRCodeService) PollDeviceToken(ctx context.Context, deviceCode, clientID string, client models.ClientInfo, dpopJKT string) (*models.TokenPair, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.PollDeviceToken")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditDeviceToken, Subject: clientID}
+
+	qrCode, err := s.qrStorage.GetQRCodeByData(ctx, deviceCode)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrInvalidGrant)
+	}
+
+	if qrCode.Type != models.QRCodeTypeDevice || qrCode.ClientID != clientID {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrInvalidGrant)
+	}
+	event.ActorID = qrCode.UserID
+
+	if qrCode.IsUsed {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrInvalidGrant)
+	}
+
+	now := s.authService.clock.Now()
+	if now.After(qrCode.ExpiresAt) {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrExpiredDeviceCode)
+	}
+
+	// The poll only writes the poll fields; the status it returns is read in
+	// the same step, so an approval cannot be missed or undone.
+	updated, tooFast, err := s.qrStorage.RecordQRCodePoll(ctx, qrCode.ID, slowDownIncrement)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrInvalidGrant)
+	}
+	event.ActorID = updated.UserID
+
+	if tooFast {
+		return nil, ErrSlowDown
+	}
+
+	switch updated.Status {
+	case models.QRCodeStatusPending:
+		return nil, ErrAuthorizationPending
+	case models.QRCodeStatusDenied:
+		return nil, s.authService.auditFailure(ctx, client, event, ErrAccessDenied)
+	}
+
+	if err := s.qrStorage.MarkQRCodeAsUsed(ctx, updated.ID, client.IPAddress); err != nil {
+		if err == storage.ErrQRCodeExpired {
+			return nil, s.authService.auditFailure(ctx, client, event, ErrExpiredDeviceCode)
+		}
+		return nil, s.authService.auditFailure(ctx, client, event, ErrInvalidGrant)
+	}
+
+	user, err := s.userStorage.GetUserByID(ctx, updated.UserID)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrInvalidGrant)
+	}
+
+	tokenPair, err := s.authService.generateTokenPair(ctx, user, tokenGrant{
+		scope:    updated.Scope,
+		audience: s.authService.clientAudience(updated.ClientID),
+		jkt:      dpopJKT,
+	})
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, fmt.Errorf("failed to generate tokens: %w", err))
+	}
+
+	event.SessionID = tokenPair.TokenFamily
+	s.authService.audit(ctx, client, event)
+	return tokenPair, nil
+}
+
+func generateUserCode() (string, error) {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// normalizeUserCode accepts the user code as typed (any case, with or without separators).
+func normalizeUserCode(userCode string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+
+	if len(cleaned) != 8 {
+		return cleaned
+	}
+	return cleaned[:4] + "-" + cleaned[4:]
+}
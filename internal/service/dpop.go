@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/tracing"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidDPoPProof = errors.New("invalid_dpop_proof")
+	ErrUseDPoPNonce     = errors.New("use_dpop_nonce")
+	ErrDPoPKeyMismatch  = errors.New("DPoP key does not match the token binding")
+)
+
+var dpopSigningMethods = []string{"ES256", "RS256", "PS256", "EdDSA"}
+
+type dpopClaims struct {
+	HTM   string `json:"htm"`
+	HTU   string `json:"htu"`
+	ATH   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// jsonWebKey holds the public members of the "jwk" header of a DPoP proof.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// VerifyDPoPProof checks a DPoP proof JWT (RFC 9449 section 4.3) for the given HTTP
+// method and URL and returns the JWK thumbprint of the key that signed it. When
+// accessToken is non-empty the proof must carry its hash in the "ath" claim.
+func (s *AuthService) VerifyDPoPProof(ctx context.Context, proof, method, requestURL, accessToken string) (string, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.VerifyDPoPProof")
+	defer span.End()
+
+	var key *jsonWebKey
+	claims := &dpopClaims{}
+
+	parser := jwt.NewParser(jwt.WithValidMethods(dpopSigningMethods), jwt.WithTimeFunc(s.clock.Now))
+	_, err := parser.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, ErrInvalidDPoPProof
+		}
+
+		raw, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, ErrInvalidDPoPProof
+		}
+		key = &jsonWebKey{}
+		if err := json.Unmarshal(raw, key); err != nil {
+			return nil, ErrInvalidDPoPProof
+		}
+		return key.publicKey()
+	})
+	if err != nil {
+		return "", ErrInvalidDPoPProof
+	}
+
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return "", ErrInvalidDPoPProof
+	}
+
+	if !strings.EqualFold(claims.HTM, method) || !sameHTU(claims.HTU, requestURL) {
+		return "", ErrInvalidDPoPProof
+	}
+
+	now := s.clock.Now()
+	issuedAt := claims.IssuedAt.Time
+	if now.Sub(issuedAt) > s.cfg().DPoPProofMaxAge || issuedAt.Sub(now) > s.cfg().DPoPProofMaxAge {
+		return "", ErrInvalidDPoPProof
+	}
+
+	if accessToken != "" {
+		ath := sha256.Sum256([]byte(accessToken))
+		if subtle.ConstantTimeCompare([]byte(claims.ATH), []byte(base64.RawURLEncoding.EncodeToString(ath[:]))) != 1 {
+			return "", ErrInvalidDPoPProof
+		}
+	}
+
+	if s.cfg().DPoPRequireNonce && !s.validDPoPNonce(claims.Nonce) {
+		return "", ErrUseDPoPNonce
+	}
+
+	jkt, err := key.thumbprint()
+	if err != nil {
+		return "", ErrInvalidDPoPProof
+	}
+
+	if err := s.dpopReplayStorage.MarkProofUsed(ctx, jkt+":"+claims.ID, issuedAt.Add(2*s.cfg().DPoPProofMaxAge)); err != nil {
+		return "", ErrInvalidDPoPProof
+	}
+
+	return jkt, nil
+}
+
+// DPoPNonce returns the server nonce clients must echo in their proofs. Nonces are
+// derived from the current time window, so no server-side state is needed; the
+// previous window's nonce is still accepted to tolerate rollover.
+func (s *AuthService) DPoPNonce() string {
+	return s.dpopNonceForWindow(s.cfg().JWTSecret, s.dpopNonceWindow())
+}
+
+// CheckTokenBinding verifies that the key proven by the client is the one the access
+// token was bound to. Unbound tokens accept any (or no) key.
+func (s *AuthService) CheckTokenBinding(claims *models.Claims, jkt string) error {
+	if claims.Cnf == nil || claims.Cnf.JKT == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Cnf.JKT), []byte(jkt)) != 1 {
+		return ErrDPoPKeyMismatch
+	}
+	return nil
+}
+
+func (s *AuthService) dpopNonceWindow() int64 {
+	return s.clock.Now().UnixNano() / int64(s.cfg().DPoPNonceLifetime)
+}
+
+func (s *AuthService) dpopNonceForWindow(secret string, window int64) string {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(window))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("dpop-nonce"))
+	mac.Write(buf)
+
+	return base64.RawURLEncoding.EncodeToString(append(buf, mac.Sum(nil)[:16]...))
+}
+
+func (s *AuthService) validDPoPNonce(nonce string) bool {
+	if nonce == "" {
+		return false
+	}
+	window := s.dpopNonceWindow()
+	for _, secret := range s.cfg().VerificationSecrets() {
+		for _, w := range []int64{window, window - 1} {
+			if hmac.Equal([]byte(nonce), []byte(s.dpopNonceForWindow(secret, w))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameHTU compares the htu claim with the request URL, ignoring query and fragment
+// as required by RFC 9449 section 4.3.
+func sameHTU(htu, requestURL string) bool {
+	a, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(requestURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host) && a.Path == b.Path
+}
+
+func (k *jsonWebKey) publicKey() (interface{}, error) {
+	if k.D != "" {
+		return nil, ErrInvalidDPoPProof
+	}
+
+	switch k.Kty {
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, ErrInvalidDPoPProof
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, ErrInvalidDPoPProof
+		}
+		// ecdh rejects points that are not on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, ErrInvalidDPoPProof
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrInvalidDPoPProof
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrInvalidDPoPProof
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, ErrInvalidDPoPProof
+}
+
+// thumbprint computes the RFC 7638 JWK SHA-256 thumbprint over the required members
+// in lexicographic order.
+func (k *jsonWebKey) thumbprint() (string, error) {
+	var members string
+	switch k.Kty {
+	case "EC":
+		members = `{"crv":"` + k.Crv + `","kty":"EC","x":"` + k.X + `","y":"` + k.Y + `"}`
+	case "RSA":
+		members = `{"e":"` + k.E + `","kty":"RSA","n":"` + k.N + `"}`
+	case "OKP":
+		members = `{"crv":"` + k.Crv + `","kty":"OKP","x":"` + k.X + `"}`
+	default:
+		return "", ErrInvalidDPoPProof
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"rotate-token-demo/internal/logging"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/storage"
+	"rotate-token-demo/internal/tracing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnknownApprovalAction = errors.New("action does not support QR approval")
+	ErrApprovalPending       = errors.New("approval is waiting for the trusted device")
+	ErrApprovalDenied        = errors.New("approval was denied")
+	ErrApprovalNotScannable  = errors.New("approval is no longer pending")
+	ErrApprovalWrongUser     = errors.New("approval belongs to another user")
+	ErrApprovalRequired      = errors.New("a valid approval token is required for this action")
+	ErrApprovalNoSession     = errors.New("approval requires a signed-in session")
+	ErrApprovalSameSession   = errors.New("approval must be confirmed from another device")
+)
+
+// Transaction approval reuses the cross-device QR flow for step-up confirmation:
+// a signed-in web session asks to perform a sensitive action, the same user's
+// trusted phone scans and approves it, and the web session collects a short-lived
+// approval token that is good for exactly one execution of that action. The
+// requesting session can neither confirm its own request nor collect the token
+// without the poll token it was given, so a stolen access token alone is not
+// enough to pass step-up.
+
+func (s *QRCodeService) RequestApproval(ctx context.Context, userID, sessionID string, req *models.ApprovalRequest, client models.ClientInfo) (*models.ApprovalStartResponse, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.RequestApproval")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditApprovalRequest, ActorID: userID, Subject: req.Action}
+
+	cfg := s.authService.cfg()
+
+	supported := false
+	for _, action := range cfg.ApprovalActions {
+		if action == req.Action {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrUnknownApprovalAction)
+	}
+	if sessionID == "" {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrApprovalNoSession)
+	}
+
+	qrID := uuid.New().String()
+	now := s.authService.clock.Now()
+	expiresAt := now.Add(cfg.ApprovalQRExpiry)
+
+	qrData, err := s.signQRPayload(qrID, expiresAt)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrQRCodeGenerationFailed)
+	}
+	pollToken, err := randomToken()
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrQRCodeGenerationFailed)
+	}
+
+	qrCode := &models.QRCode{
+		ID:                 qrID,
+		Type:               models.QRCodeTypeApproval,
+		Data:               qrData,
+		UserID:             userID,
+		CreatedAt:          now,
+		ExpiresAt:          expiresAt,
+		Status:             models.QRCodeStatusPending,
+		Action:             req.Action,
+		RequesterIP:        client.IPAddress,
+		RequesterAgent:     client.UserAgent,
+		RequesterSessionID: sessionID,
+		PollTokenHash:      hashPollToken(pollToken),
+	}
+
+	if err := s.qrStorage.CreateQRCode(ctx, qrCode); err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, fmt.Errorf("failed to store approval: %w", err))
+	}
+
+	s.authService.audit(ctx, client, event)
+	return &models.ApprovalStartResponse{
+		ID:        qrCode.ID,
+		QRData:    qrData,
+		PollToken: logging.Secret(pollToken),
+		Action:    qrCode.Action,
+		ExpiresAt: qrCode.ExpiresAt,
+	}, nil
+}
+
+// ScanApproval is called by the trusted phone when it reads the code. Only the
+// user who requested the action may approve it, and only from another session.
+func (s *QRCodeService) ScanApproval(ctx context.Context, userID, sessionID, qrData string) (*models.ApprovalDetails, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.ScanApproval")
+	defer span.End()
+
+	qrCode, err := s.getQRCodeByPayload(ctx, qrData, models.QRCodeTypeApproval)
+	if err != nil {
+		return nil, err
+	}
+
+	if qrCode.UserID != userID {
+		return nil, ErrApprovalWrongUser
+	}
+	if sessionID == "" || sessionID == qrCode.RequesterSessionID {
+		return nil, ErrApprovalSameSession
+	}
+
+	switch qrCode.Status {
+	case models.QRCodeStatusScanned:
+		return describeApproval(qrCode), nil
+	case models.QRCodeStatusPending:
+	default:
+		return nil, ErrApprovalNotScannable
+	}
+
+	updated, err := s.qrStorage.TransitionQRCode(ctx, qrCode.ID, storage.QRCodeTransition{
+		From:      models.QRCodeStatusPending,
+		To:        models.QRCodeStatusScanned,
+		ScannedBy: userID,
+	})
+	if err != nil {
+		if err == storage.ErrQRCodeStateChanged {
+			return nil, ErrApprovalNotScannable
+		}
+		return nil, err
+	}
+
+	return describeApproval(updated), nil
+}
+
+// DecideApproval records the phone's approval or denial of a scanned request.
+func (s *QRCodeService) DecideApproval(ctx context.Context, userID, sessionID, qrData string, approve bool, client models.ClientInfo) (*models.ApprovalDetails, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.DecideApproval")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditApprovalDecide, ActorID: userID}
+
+	qrCode, err := s.getQRCodeByPayload(ctx, qrData, models.QRCodeTypeApproval)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
+	}
+
+	if qrCode.UserID != userID {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrApprovalWrongUser)
+	}
+	if sessionID == "" || sessionID == qrCode.RequesterSessionID {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrApprovalSameSession)
+	}
+	if qrCode.Status != models.QRCodeStatusScanned {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrApprovalNotScannable)
+	}
+
+	transition := storage.QRCodeTransition{From: models.QRCodeStatusScanned, To: models.QRCodeStatusDenied}
+	if approve {
+		transition.To = models.QRCodeStatusApproved
+	}
+
+	event.Subject = qrCode.Action
+	updated, err := s.qrStorage.TransitionQRCode(ctx, qrCode.ID, transition)
+	if err != nil {
+		if err == storage.ErrQRCodeStateChanged {
+			err = ErrApprovalNotScannable
+		}
+		return nil, s.authService.auditFailure(ctx, client, event, err)
+	}
+
+	if !approve {
+		event.Reason = "denied"
+	}
+	s.authService.audit(ctx, client, event)
+	return describeApproval(updated), nil
+}
+
+// ClaimApprovalToken hands the requesting session its approval token once the
+// phone has approved, in exchange for the poll token returned by RequestApproval. The token never outlives the approval record, and it is the
+// record, not the token, that is consumed on redemption, so claiming twice does
+// not allow the action to run twice.
+func (s *QRCodeService) ClaimApprovalToken(ctx context.Context, userID, id, pollToken string) (*models.ApprovalTokenResponse, string, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.ClaimApprovalToken")
+	defer span.End()
+
+	qrCode, err := s.qrStorage.GetQRCode(ctx, id)
+	if err != nil || qrCode.Type != models.QRCodeTypeApproval || qrCode.UserID != userID {
+		return nil, "", storage.ErrQRCodeNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(qrCode.PollTokenHash), []byte(hashPollToken(pollToken))) != 1 {
+		return nil, "", storage.ErrQRCodeNotFound
+	}
+
+	if qrCode.IsUsed {
+		return nil, qrCode.Status, storage.ErrQRCodeUsed
+	}
+
+	now := s.authService.clock.Now()
+	if now.After(qrCode.ExpiresAt) {
+		return nil, qrCode.Status, storage.ErrQRCodeExpired
+	}
+
+	switch qrCode.Status {
+	case models.QRCodeStatusPending, models.QRCodeStatusScanned:
+		return nil, qrCode.Status, ErrApprovalPending
+	case models.QRCodeStatusDenied:
+		return nil, qrCode.Status, ErrApprovalDenied
+	}
+
+	cfg := s.authService.cfg()
+	expiresAt := now.Add(cfg.ApprovalTokenExpiry)
+	if qrCode.ExpiresAt.Before(expiresAt) {
+		expiresAt = qrCode.ExpiresAt
+	}
+
+	claims := &models.ApprovalClaims{
+		UserID: userID,
+		Action: qrCode.Action,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        qrCode.ID,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{cfg.ApprovalAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+
+	approvalToken, err := s.authService.signClaims(ctx, claims)
+	if err != nil {
+		return nil, qrCode.Status, fmt.Errorf("failed to sign approval token: %w", err)
+	}
+
+	return &models.ApprovalTokenResponse{
+		ApprovalToken: approvalToken,
+		Action:        qrCode.Action,
+		ExpiresAt:     expiresAt,
+	}, qrCode.Status, nil
+}
+
+// VerifyApprovalToken checks that approvalToken authorises userID to perform
+// action and returns the approval it refers to, without using it up. The caller
+// performs the action and then calls ConsumeApproval, so a request that fails
+// validation leaves the approval for a corrected retry.
+func (s *QRCodeService) VerifyApprovalToken(ctx context.Context, approvalToken, userID, action string, client models.ClientInfo) (string, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.VerifyApprovalToken")
+	defer span.End()
+
+	cfg := s.authService.cfg()
+	event := models.AuditEvent{Type: models.AuditApprovalRedeem, ActorID: userID, Subject: action}
+
+	claims := &models.ApprovalClaims{}
+	token, err := jwt.ParseWithClaims(approvalToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return verificationKeys(cfg), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(cfg.ApprovalAudience),
+		jwt.WithTimeFunc(s.authService.clock.Now))
+	if err != nil || !token.Valid {
+		return "", s.authService.auditFailure(ctx, client, event, ErrApprovalRequired)
+	}
+
+	if claims.UserID != userID || claims.Action != action || claims.ID == "" {
+		return "", s.authService.auditFailure(ctx, client, event, ErrApprovalRequired)
+	}
+
+	qrCode, err := s.qrStorage.GetQRCode(ctx, claims.ID)
+	if err != nil || qrCode.Type != models.QRCodeTypeApproval || qrCode.IsUsed ||
+		qrCode.Status != models.QRCodeStatusApproved || qrCode.Action != action {
+		return "", s.authService.auditFailure(ctx, client, event, ErrApprovalRequired)
+	}
+
+	return qrCode.ID, nil
+}
+
+// ConsumeApproval marks a verified approval as used once the action it guarded
+// has succeeded.
+func (s *QRCodeService) ConsumeApproval(ctx context.Context, id, userID, action string, client models.ClientInfo) error {
+	ctx, span := tracing.Start(ctx, "QRCodeService.ConsumeApproval")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditApprovalRedeem, ActorID: userID, Subject: action}
+
+	if err := s.qrStorage.MarkQRCodeAsUsed(ctx, id, client.IPAddress); err != nil {
+		return s.authService.auditFailure(ctx, client, event, ErrApprovalRequired)
+	}
+
+	s.authService.audit(ctx, client, event)
+	return nil
+}
+
+func describeApproval(qrCode *models.QRCode) *models.ApprovalDetails {
+	return &models.ApprovalDetails{
+		ID:        qrCode.ID,
+		Action:    qrCode.Action,
+		UserAgent: qrCode.RequesterAgent,
+		IPAddress: qrCode.RequesterIP,
+		Status:    qrCode.Status,
+		CreatedAt: qrCode.CreatedAt,
+		ExpiresAt: qrCode.ExpiresAt,
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/netip"
+	"rotate-token-demo/internal/models"
+	"strings"
+)
+
+var (
+	ErrInvalidQRBinding   = errors.New("invalid QR binding policy")
+	ErrQRBindingViolation = errors.New("QR code cannot be redeemed from this device or network")
+)
+
+const (
+	QRBindingReasonNetwork     = "network_mismatch"
+	QRBindingReasonAllowList   = "ip_not_allowed"
+	QRBindingReasonFingerprint = "fingerprint_mismatch"
+
+	maxQRBindingAllowedIPs = 16
+)
+
+// normalizeQRBinding validates a requested policy and returns the form that is
+// stored with the code: allow-list entries become canonical prefixes, the
+// generator's address is pinned and the fingerprint is hashed. An empty policy
+// yields nil.
+func normalizeQRBinding(policy *models.QRBindingPolicy, generatorIP string) (*models.QRBindingPolicy, error) {
+	if policy == nil || (!policy.SameNetwork && len(policy.AllowedIPs) == 0 && policy.DeviceFingerprint == "") {
+		return nil, nil
+	}
+
+	if len(policy.AllowedIPs) > maxQRBindingAllowedIPs {
+		return nil, ErrInvalidQRBinding
+	}
+
+	normalized := &models.QRBindingPolicy{SameNetwork: policy.SameNetwork}
+
+	if policy.SameNetwork {
+		if _, err := netip.ParseAddr(generatorIP); err != nil {
+			return nil, ErrInvalidQRBinding
+		}
+		normalized.GeneratorIP = generatorIP
+	}
+
+	for _, entry := range policy.AllowedIPs {
+		prefix, err := parseAllowedIP(entry)
+		if err != nil {
+			return nil, ErrInvalidQRBinding
+		}
+		normalized.AllowedIPs = append(normalized.AllowedIPs, prefix.String())
+	}
+
+	if fingerprint := strings.TrimSpace(policy.DeviceFingerprint); fingerprint != "" {
+		normalized.DeviceFingerprint = hashPollToken(fingerprint)
+	}
+
+	return normalized, nil
+}
+
+// checkQRBinding returns the reason client breaks policy, or "" if it complies.
+func checkQRBinding(policy *models.QRBindingPolicy, client models.ClientInfo) string {
+	if policy == nil {
+		return ""
+	}
+
+	addr, err := netip.ParseAddr(client.IPAddress)
+	if err != nil && (policy.SameNetwork || len(policy.AllowedIPs) > 0) {
+		return QRBindingReasonNetwork
+	}
+	addr = addr.Unmap()
+
+	if policy.SameNetwork && !sameNetwork(netip.MustParseAddr(policy.GeneratorIP).Unmap(), addr) {
+		return QRBindingReasonNetwork
+	}
+
+	if len(policy.AllowedIPs) > 0 {
+		allowed := false
+		for _, entry := range policy.AllowedIPs {
+			if prefix, err := netip.ParsePrefix(entry); err == nil && prefix.Contains(addr) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return QRBindingReasonAllowList
+		}
+	}
+
+	if policy.DeviceFingerprint != "" {
+		fingerprint := strings.TrimSpace(client.DeviceFingerprint)
+		if fingerprint == "" ||
+			subtle.ConstantTimeCompare([]byte(policy.DeviceFingerprint), []byte(hashPollToken(fingerprint))) != 1 {
+			return QRBindingReasonFingerprint
+		}
+	}
+
+	return ""
+}
+
+// enforceQRBinding checks a redemption attempt and records a violation on the
+// code when it is refused. The code itself stays valid so an attacker who
+// photographed it cannot burn it for its owner.
+func (s *QRCodeService) enforceQRBinding(ctx context.Context, qrCode *models.QRCode, client models.ClientInfo) error {
+	reason := checkQRBinding(qrCode.Binding, client)
+	if reason == "" {
+		return nil
+	}
+
+	if err := s.qrStorage.RecordBindingViolation(ctx, qrCode.ID, models.QRBindingViolation{
+		Reason:    reason,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		Timestamp: s.authService.clock.Now(),
+	}); err != nil {
+		slog.WarnContext(ctx, "failed to record QR binding violation", "qr_id", qrCode.ID, "error", err)
+	}
+	s.authService.audit(ctx, client, models.AuditEvent{
+		Type:    models.AuditQRBindingViolation,
+		ActorID: qrCode.UserID,
+		Subject: qrCode.ID,
+		Outcome: models.AuditOutcomeFailure,
+		Reason:  reason,
+	})
+
+	return ErrQRBindingViolation
+}
+
+// sameNetwork compares the /24 of IPv4 or the /64 of IPv6 addresses.
+func sameNetwork(a, b netip.Addr) bool {
+	if a.Is4() != b.Is4() {
+		return false
+	}
+	bits := 64
+	if a.Is4() {
+		bits = 24
+	}
+	pa, _ := a.Prefix(bits)
+	pb, _ := b.Prefix(bits)
+	return pa == pb
+}
+
+func parseAllowedIP(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/qrimage"
+	"rotate-token-demo/internal/storage"
+	"rotate-token-demo/internal/tracing"
+)
+
+var ErrQRLogoUnavailable = errors.New("no QR logo is configured")
+
+// RenderQRCodeImage draws the QR code identified by qrID for its owner. Codes that
+// belong to someone else are reported as not found so their existence is not
+// revealed, and codes that were used or expired can no longer be rendered.
+func (s *QRCodeService) RenderQRCodeImage(ctx context.Context, userID, qrID string, opts qrimage.Options, withLogo bool) ([]byte, *models.QRCode, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.RenderQRCodeImage")
+	defer span.End()
+
+	qrCode, err := s.qrStorage.GetQRCode(ctx, qrID)
+	if err != nil || qrCode.UserID != userID ||
+		(qrCode.Type != models.QRCodeTypeLogin && qrCode.Type != models.QRCodeTypeApproval) {
+		return nil, nil, storage.ErrQRCodeNotFound
+	}
+
+	if qrCode.IsUsed {
+		return nil, nil, storage.ErrQRCodeUsed
+	}
+
+	if s.authService.clock.Now().After(qrCode.ExpiresAt) {
+		return nil, nil, storage.ErrQRCodeExpired
+	}
+
+	if withLogo {
+		logo := s.loadLogo()
+		if logo == nil {
+			return nil, nil, ErrQRLogoUnavailable
+		}
+		opts.Logo = logo
+	}
+
+	data, err := qrimage.Render(qrCode.Data, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, qrCode, nil
+}
+
+// loadLogo decodes the configured logo once; a missing or unreadable file simply
+// disables logo embedding.
+func (s *QRCodeService) loadLogo() image.Image {
+	s.logoOnce.Do(func() {
+		path := s.authService.cfg().QRLogoPath
+		if path == "" {
+			return
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		if logo, _, err := image.Decode(file); err == nil {
+			s.logo = logo
+		}
+	})
+	return s.logo
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"rotate-token-demo/internal/logging"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/storage"
+	"rotate-token-demo/internal/tracing"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrQRLoginPending       = errors.New("QR login is waiting for approval")
+	ErrQRLoginDenied        = errors.New("QR login was denied")
+	ErrQRLoginNotScannable  = errors.New("QR login is no longer pending")
+	ErrQRLoginScannedByUser = errors.New("QR login was scanned by another user")
+)
+
+// Cross-device QR login works the other way round from GenerateQRCode: the new,
+// unauthenticated device asks for a challenge and displays it, the phone that is
+// already signed in scans and approves it, and the new device then collects its
+// own token family with the poll token it was given. The QR payload alone is
+// useless to whoever photographs it.
+
+func (s *QRCodeService) StartQRLogin(ctx context.Context, req *models.QRLoginStartRequest, client models.ClientInfo) (*models.QRLoginStartResponse, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.StartQRLogin")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditQRLoginStart}
+
+	cfg := s.authService.cfg()
+
+	scope, err := resolveScope(req.Scope, cfg.DefaultScopes, cfg.SupportedScopes)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
+	}
+
+	qrID := uuid.New().String()
+	now := s.authService.clock.Now()
+	expiresAt := now.Add(cfg.QRLoginExpiry)
+
+	qrData, err := s.signQRPayload(qrID, expiresAt)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrQRCodeGenerationFailed)
+	}
+	pollToken, err := randomToken()
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrQRCodeGenerationFailed)
+	}
+
+	qrCode := &models.QRCode{
+		ID:             qrID,
+		Type:           models.QRCodeTypeCrossDevice,
+		Data:           qrData,
+		CreatedAt:      now,
+		ExpiresAt:      expiresAt,
+		Status:         models.QRCodeStatusPending,
+		Scope:          scope,
+		DeviceName:     req.DeviceName,
+		RequesterIP:    client.IPAddress,
+		RequesterAgent: client.UserAgent,
+		PollTokenHash:  hashPollToken(pollToken),
+	}
+
+	if err := s.qrStorage.CreateQRCode(ctx, qrCode); err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, fmt.Errorf("failed to store QR login: %w", err))
+	}
+
+	event.Subject = qrCode.ID
+	s.authService.audit(ctx, client, event)
+	return &models.QRLoginStartResponse{
+		ID:        qrCode.ID,
+		QRData:    qrData,
+		PollToken: logging.Secret(pollToken),
+		ExpiresAt: qrCode.ExpiresAt,
+	}, nil
+}
+
+// ScanQRLogin is called by the signed-in phone when it reads the code. It claims the
+// challenge for that user and returns the requesting device for the approval prompt.
+func (s *QRCodeService) ScanQRLogin(ctx context.Context, userID, qrData string) (*models.QRLoginDevice, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.ScanQRLogin")
+	defer span.End()
+
+	qrCode, err := s.getQRCodeByPayload(ctx, qrData, models.QRCodeTypeCrossDevice)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case qrCode.Status == models.QRCodeStatusScanned && qrCode.ScannedBy == userID:
+		return describeQRLoginDevice(qrCode), nil
+	case qrCode.Status == models.QRCodeStatusScanned:
+		return nil, ErrQRLoginScannedByUser
+	case qrCode.Status != models.QRCodeStatusPending:
+		return nil, ErrQRLoginNotScannable
+	}
+
+	updated, err := s.qrStorage.TransitionQRCode(ctx, qrCode.ID, storage.QRCodeTransition{
+		From:      models.QRCodeStatusPending,
+		To:        models.QRCodeStatusScanned,
+		ScannedBy: userID,
+	})
+	if err != nil {
+		if err == storage.ErrQRCodeStateChanged {
+			return nil, ErrQRLoginNotScannable
+		}
+		return nil, err
+	}
+
+	return describeQRLoginDevice(updated), nil
+}
+
+// DecideQRLogin records the phone user's approval or denial of a scanned challenge.
+func (s *QRCodeService) DecideQRLogin(ctx context.Context, userID, qrData string, approve bool, client models.ClientInfo) (*models.QRLoginDevice, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.DecideQRLogin")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditQRLoginDecide, ActorID: userID}
+
+	qrCode, err := s.getQRCodeByPayload(ctx, qrData, models.QRCodeTypeCrossDevice)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
+	}
+
+	if qrCode.Status != models.QRCodeStatusScanned {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrQRLoginNotScannable)
+	}
+	if qrCode.ScannedBy != userID {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrQRLoginScannedByUser)
+	}
+	if approve {
+		if err := s.checkUserScope(ctx, userID, qrCode.Scope); err != nil {
+			return nil, s.authService.auditFailure(ctx, client, event, err)
+		}
+	}
+
+	transition := storage.QRCodeTransition{From: models.QRCodeStatusScanned, To: models.QRCodeStatusDenied}
+	if approve {
+		transition.To = models.QRCodeStatusApproved
+		transition.UserID = userID
+	}
+
+	event.Subject = qrCode.ID
+	updated, err := s.qrStorage.TransitionQRCode(ctx, qrCode.ID, transition)
+	if err != nil {
+		if err == storage.ErrQRCodeStateChanged {
+			err = ErrQRLoginNotScannable
+		}
+		return nil, s.authService.auditFailure(ctx, client, event, err)
+	}
+
+	if !approve {
+		event.Reason = "denied"
+	}
+	s.authService.audit(ctx, client, event)
+	return describeQRLoginDevice(updated), nil
+}
+
+// ClaimQRLogin lets the waiting device collect a fresh token family once its
+// challenge is approved. It returns the current status alongside ErrQRLoginPending
+// while the phone has not decided yet.
+func (s *QRCodeService) ClaimQRLogin(ctx context.Context, id, pollToken string, client models.ClientInfo, dpopJKT string) (*models.TokenPair, string, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.ClaimQRLogin")
+	defer span.End()
+
+	qrCode, err := s.qrStorage.GetQRCode(ctx, id)
+	if err != nil || qrCode.Type != models.QRCodeTypeCrossDevice {
+		return nil, "", storage.ErrQRCodeNotFound
+	}
+
+	if subtle.ConstantTimeCompare([]byte(qrCode.PollTokenHash), []byte(hashPollToken(pollToken))) != 1 {
+		return nil, "", storage.ErrQRCodeNotFound
+	}
+
+	if qrCode.IsUsed {
+		return nil, qrCode.Status, storage.ErrQRCodeUsed
+	}
+
+	if s.authService.clock.Now().After(qrCode.ExpiresAt) {
+		return nil, qrCode.Status, storage.ErrQRCodeExpired
+	}
+
+	switch qrCode.Status {
+	case models.QRCodeStatusPending, models.QRCodeStatusScanned:
+		return nil, qrCode.Status, ErrQRLoginPending
+	case models.QRCodeStatusDenied:
+		return nil, qrCode.Status, ErrQRLoginDenied
+	}
+
+	event := models.AuditEvent{Type: models.AuditQRLoginClaim, ActorID: qrCode.UserID, Subject: qrCode.ID}
+
+	if err := s.qrStorage.MarkQRCodeAsUsed(ctx, qrCode.ID, client.IPAddress); err != nil {
+		return nil, qrCode.Status, s.authService.auditFailure(ctx, client, event, err)
+	}
+
+	user, err := s.userStorage.GetUserByID(ctx, qrCode.UserID)
+	if err != nil {
+		return nil, qrCode.Status, s.authService.auditFailure(ctx, client, event, fmt.Errorf("user not found: %w", err))
+	}
+
+	tokenPair, err := s.authService.generateTokenPair(ctx, user, tokenGrant{
+		scope:    qrCode.Scope,
+		audience: []string{s.authService.cfg().AccessTokenAudience},
+		jkt:      dpopJKT,
+	})
+	if err != nil {
+		return nil, qrCode.Status, s.authService.auditFailure(ctx, client, event, fmt.Errorf("failed to generate tokens: %w", err))
+	}
+
+	event.SessionID = tokenPair.TokenFamily
+	s.authService.audit(ctx, client, event)
+	return tokenPair, qrCode.Status, nil
+}
+
+// getQRCodeByPayload resolves a scanned payload to an unused, unexpired code of qrType.
+func (s *QRCodeService) getQRCodeByPayload(ctx context.Context, qrData, qrType string) (*models.QRCode, error) {
+	qrID, err := s.verifyQRPayload(qrData)
+	if err != nil {
+		return nil, err
+	}
+
+	qrCode, err := s.qrStorage.GetQRCode(ctx, qrID)
+	if err != nil || qrCode.Type != qrType ||
+		subtle.ConstantTimeCompare([]byte(qrCode.Data), []byte(qrData)) != 1 {
+		return nil, storage.ErrQRCodeNotFound
+	}
+
+	if qrCode.IsUsed {
+		return nil, storage.ErrQRCodeUsed
+	}
+
+	if s.authService.clock.Now().After(qrCode.ExpiresAt) {
+		return nil, storage.ErrQRCodeExpired
+	}
+
+	return qrCode, nil
+}
+
+func describeQRLoginDevice(qrCode *models.QRCode) *models.QRLoginDevice {
+	return &models.QRLoginDevice{
+		ID:         qrCode.ID,
+		DeviceName: qrCode.DeviceName,
+		UserAgent:  qrCode.RequesterAgent,
+		IPAddress:  qrCode.RequesterIP,
+		Status:     qrCode.Status,
+		CreatedAt:  qrCode.CreatedAt,
+		ExpiresAt:  qrCode.ExpiresAt,
+	}
+}
+
+func randomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func hashPollToken(pollToken string) string {
+	sum := sha256.Sum256([]byte(pollToken))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"rotate-token-demo/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QR payloads are compact, self-verifying tokens of the form
+//
+//	v1.<base64url(qr id | nonce | expiry)>.<base64url(hmac)>
+//
+// They carry nothing but an opaque record ID, a random nonce and the expiry, so a
+// photographed code reveals no user identity, and forged or tampered codes are
+// rejected before storage is touched.
+const (
+	qrPayloadVersion  = "v1"
+	qrPayloadNonceLen = 16
+	qrPayloadMACLen   = 16
+	qrPayloadBodyLen  = 16 + qrPayloadNonceLen + 8
+)
+
+func (s *QRCodeService) signQRPayload(qrID string, expiresAt time.Time) (string, error) {
+	id, err := uuid.Parse(qrID)
+	if err != nil {
+		return "", err
+	}
+
+	body := make([]byte, 0, qrPayloadBodyLen)
+	body = append(body, id[:]...)
+
+	nonce := make([]byte, qrPayloadNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	body = append(body, nonce...)
+	body = binary.BigEndian.AppendUint64(body, uint64(expiresAt.Unix()))
+
+	return qrPayloadVersion + "." +
+		base64.RawURLEncoding.EncodeToString(body) + "." +
+		base64.RawURLEncoding.EncodeToString(qrPayloadMAC(s.authService.cfg().JWTSecret, body)), nil
+}
+
+// verifyQRPayload checks the signature and expiry of a QR payload and returns the
+// QR record ID it refers to.
+func (s *QRCodeService) verifyQRPayload(payload string) (string, error) {
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 || parts[0] != qrPayloadVersion {
+		return "", ErrQRCodeValidationFailed
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(body) != qrPayloadBodyLen {
+		return "", ErrQRCodeValidationFailed
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !s.validQRPayloadMAC(body, mac) {
+		return "", ErrQRCodeValidationFailed
+	}
+
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(body[16+qrPayloadNonceLen:])), 0)
+	if s.authService.clock.Now().After(expiresAt) {
+		return "", storage.ErrQRCodeExpired
+	}
+
+	id, err := uuid.FromBytes(body[:16])
+	if err != nil {
+		return "", ErrQRCodeValidationFailed
+	}
+
+	return id.String(), nil
+}
+
+// validQRPayloadMAC accepts a MAC made with the current or a previous secret, so
+// codes issued before a key rotation can still be scanned.
+func (s *QRCodeService) validQRPayloadMAC(body, mac []byte) bool {
+	for _, secret := range s.authService.cfg().VerificationSecrets() {
+		if hmac.Equal(mac, qrPayloadMAC(secret, body)) {
+			return true
+		}
+	}
+	return false
+}
+
+func qrPayloadMAC(secret string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("qr-payload:" + qrPayloadVersion + ":"))
+	mac.Write(body)
+	return mac.Sum(nil)[:qrPayloadMACLen]
+}
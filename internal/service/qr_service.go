@@ -1,13 +1,15 @@
 package service
 
 import (
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"image"
 	"rotate-token-demo/internal/models"
 	"rotate-token-demo/internal/storage"
+	"rotate-token-demo/internal/tracing"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,149 +18,300 @@ import (
 var (
 	ErrQRCodeGenerationFailed = errors.New("failed to generate QR code")
 	ErrQRCodeValidationFailed = errors.New("QR code validation failed")
+	ErrInvalidQRCodeTTL       = errors.New("QR code TTL is out of the allowed range")
+	ErrTooManyQRCodes         = errors.New("too many active QR codes")
 )
 
 type QRCodeService struct {
-	qrStorage    storage.QRCodeStorage
-	userStorage  storage.UserStorage
-	tokenStorage storage.TokenStorage
-	authService  *AuthService
+	qrStorage      storage.QRCodeStorage
+	userStorage    storage.UserStorage
+	tokenStorage   storage.TokenStorage
+	attemptStorage storage.AttemptStorage
+	authService    *AuthService
+
+	logoOnce sync.Once
+	logo     image.Image
 }
 
-func NewQRCodeService(qrStorage storage.QRCodeStorage, userStorage storage.UserStorage, tokenStorage storage.TokenStorage, authService *AuthService) *QRCodeService {
+func NewQRCodeService(qrStorage storage.QRCodeStorage, userStorage storage.UserStorage, tokenStorage storage.TokenStorage, attemptStorage storage.AttemptStorage, authService *AuthService) *QRCodeService {
 	return &QRCodeService{
-		qrStorage:    qrStorage,
-		userStorage:  userStorage,
-		tokenStorage: tokenStorage,
-		authService:  authService,
+		qrStorage:      qrStorage,
+		userStorage:    userStorage,
+		tokenStorage:   tokenStorage,
+		attemptStorage: attemptStorage,
+		authService:    authService,
 	}
 }
 
-func (s *QRCodeService) GenerateQRCode(userID string) (*models.QRCodeResponse, error) {
-	user, err := s.userStorage.GetUserByID(userID)
-	if err != nil {
-		return nil, fmt.Errorf("user not found: %w", err)
-	}
+// GenerateQRCode issues a login QR code for the user. A zero ttl selects the
+// configured default; anything else must lie within the configured bounds.
+func (s *QRCodeService) GenerateQRCode(ctx context.Context, userID string, req *models.QRCodeGenerateRequest, client models.ClientInfo) (*models.QRCodeResponse, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.GenerateQRCode")
+	defer span.End()
 
-	qrID := uuid.New().String()
+	event := models.AuditEvent{Type: models.AuditQRGenerate, ActorID: userID}
+
+	cfg := s.authService.cfg()
 
-	randomData := make([]byte, 32)
-	if _, err := rand.Read(randomData); err != nil {
-		return nil, ErrQRCodeGenerationFailed
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl == 0 {
+		ttl = cfg.QRCodeTTL
+	}
+	if ttl < cfg.QRCodeMinTTL || ttl > cfg.QRCodeMaxTTL {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrInvalidQRCodeTTL)
 	}
 
-	qrData := map[string]interface{}{
-		"type":       "login",
-		"user_id":    userID,
-		"username":   user.Username,
-		"qr_id":      qrID,
-		"expires_at": time.Now().Add(5 * time.Minute).Unix(),
+	binding, err := normalizeQRBinding(req.Binding, client.IPAddress)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
 	}
 
-	jsonData, err := json.Marshal(qrData)
+	user, err := s.userStorage.GetUserByID(ctx, userID)
 	if err != nil {
-		return nil, ErrQRCodeGenerationFailed
+		return nil, s.authService.auditFailure(ctx, client, event, fmt.Errorf("user not found: %w", err))
 	}
 
-	encodedData := base64.URLEncoding.EncodeToString(jsonData)
+	active, err := s.countActiveQRCodes(ctx, userID)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
+	}
+	if active >= cfg.QRMaxActivePerUser {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrTooManyQRCodes)
+	}
+
+	qrID := uuid.New().String()
+	now := s.authService.clock.Now()
+	expiresAt := now.Add(ttl)
+
+	encodedData, err := s.signQRPayload(qrID, expiresAt)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrQRCodeGenerationFailed)
+	}
 
 	qrCode := &models.QRCode{
 		ID:        qrID,
+		Type:      models.QRCodeTypeLogin,
 		Data:      encodedData,
 		UserID:    userID,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(5 * time.Minute),
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
 		IsUsed:    false,
+		Status:    models.QRCodeStatusPending,
+		Binding:   binding,
 	}
 
-	if err := s.qrStorage.CreateQRCode(qrCode); err != nil {
-		return nil, fmt.Errorf("failed to store QR code: %w", err)
+	if req.UserCode {
+		err = s.createWithUserCode(ctx, qrCode)
+	} else {
+		err = s.qrStorage.CreateQRCode(ctx, qrCode)
+	}
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, fmt.Errorf("failed to store QR code: %w", err))
 	}
 
+	event.Subject = qrID
+	s.authService.audit(ctx, client, event)
 	return &models.QRCodeResponse{
 		ID:        qrID,
 		QRData:    encodedData,
+		UserCode:  qrCode.UserCode,
 		ExpiresAt: qrCode.ExpiresAt,
-		Message:   fmt.Sprintf("QR code generated for %s. Expires in 5 minutes.", user.Username),
+		Message:   fmt.Sprintf("QR code generated for %s. Expires in %s.", user.Username, ttl),
 	}, nil
 }
 
-func (s *QRCodeService) ValidateQRCode(qrData string, clientIP string) (*models.TokenPair, error) {
-	qrCode, err := s.qrStorage.GetQRCodeByData(qrData)
+// ListUserQRCodes returns the login QR codes the user generated that are still
+// active or have been used.
+func (s *QRCodeService) ListUserQRCodes(ctx context.Context, userID string) ([]*models.QRCodeSummary, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.ListUserQRCodes")
+	defer span.End()
+
+	qrCodes, err := s.userQRCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.authService.clock.Now()
+	summaries := make([]*models.QRCodeSummary, 0, len(qrCodes))
+	for _, qrCode := range qrCodes {
+		status := storage.CurrentQRCodeStatus(qrCode, now)
+		if status != models.QRCodeStatusPending && status != models.QRCodeStatusUsed {
+			continue
+		}
+		summaries = append(summaries, &models.QRCodeSummary{
+			ID:        qrCode.ID,
+			Status:    status,
+			CreatedAt: qrCode.CreatedAt,
+			ExpiresAt: qrCode.ExpiresAt,
+			UsedAt:    qrCode.UsedAt,
+			IPAddress: qrCode.IPAddress,
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].CreatedAt.After(summaries[j].CreatedAt)
+	})
+
+	return summaries, nil
+}
+
+// RevokeQRCode deletes one of the user's own QR codes so it can no longer be redeemed.
+func (s *QRCodeService) RevokeQRCode(ctx context.Context, userID, qrID string, client models.ClientInfo) error {
+	ctx, span := tracing.Start(ctx, "QRCodeService.RevokeQRCode")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditQRRevoke, ActorID: userID, Subject: qrID}
+
+	qrCode, err := s.qrStorage.GetQRCode(ctx, qrID)
+	if err != nil || qrCode.UserID != userID || qrCode.Type != models.QRCodeTypeLogin {
+		return s.authService.auditFailure(ctx, client, event, storage.ErrQRCodeNotFound)
+	}
+
+	if err := s.qrStorage.DeleteQRCode(ctx, qrID); err != nil {
+		return s.authService.auditFailure(ctx, client, event, err)
+	}
+
+	s.authService.audit(ctx, client, event)
+	return nil
+}
+
+func (s *QRCodeService) userQRCodes(ctx context.Context, userID string) ([]*models.QRCode, error) {
+	qrCodes, err := s.qrStorage.GetUserQRCodes(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get QR codes: %w", err)
+	}
+
+	var owned []*models.QRCode
+	for _, qrCode := range qrCodes {
+		if qrCode.Type == models.QRCodeTypeLogin {
+			owned = append(owned, qrCode)
+		}
+	}
+	return owned, nil
+}
+
+func (s *QRCodeService) countActiveQRCodes(ctx context.Context, userID string) (int, error) {
+	qrCodes, err := s.userQRCodes(ctx, userID)
 	if err != nil {
-		return nil, ErrQRCodeValidationFailed
+		return 0, err
+	}
+
+	now := s.authService.clock.Now()
+	active := 0
+	for _, qrCode := range qrCodes {
+		if storage.CurrentQRCodeStatus(qrCode, now) == models.QRCodeStatusPending {
+			active++
+		}
+	}
+	return active, nil
+}
+
+func (s *QRCodeService) ValidateQRCode(ctx context.Context, qrData string, client models.ClientInfo, requestedScope string, dpopJKT string) (*models.TokenPair, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.ValidateQRCode")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditQRRedeem}
+
+	qrID, err := s.verifyQRPayload(qrData)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
+	}
+
+	event.Subject = qrID
+
+	// Type never changes after creation, so checking it before consuming keeps a
+	// device or cross-device payload from being burnt through this endpoint.
+	qrCode, err := s.qrStorage.GetQRCode(ctx, qrID)
+	if err != nil || qrCode.Type != models.QRCodeTypeLogin {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrQRCodeValidationFailed)
 	}
 
-	if qrCode.IsUsed {
-		return nil, storage.ErrQRCodeUsed
+	scope, err := resolveScope(requestedScope, s.authService.cfg().DefaultScopes, s.authService.cfg().SupportedScopes)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
 	}
 
-	if time.Now().After(qrCode.ExpiresAt) {
-		return nil, storage.ErrQRCodeExpired
+	return s.redeemLoginQRCode(ctx, qrCode, client, scope, dpopJKT, event)
+}
+
+// redeemLoginQRCode enforces the code's binding policy, consumes it and issues a
+// new token family for its owner, completing the caller's audit event.
+func (s *QRCodeService) redeemLoginQRCode(ctx context.Context, qrCode *models.QRCode, client models.ClientInfo, scope, dpopJKT string, event models.AuditEvent) (*models.TokenPair, error) {
+	event.ActorID = qrCode.UserID
+
+	if err := s.enforceQRBinding(ctx, qrCode, client); err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
+	}
+	if err := s.checkUserScope(ctx, qrCode.UserID, scope); err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
 	}
 
-	if err := s.qrStorage.MarkQRCodeAsUsed(qrCode.ID, clientIP); err != nil {
-		return nil, fmt.Errorf("failed to mark QR code as used: %w", err)
+	qrCode, err := s.qrStorage.ConsumeQRCode(ctx, qrCode.Data, client.IPAddress)
+	if err != nil {
+		switch err {
+		case storage.ErrQRCodeUsed, storage.ErrQRCodeExpired:
+			return nil, s.authService.auditFailure(ctx, client, event, err)
+		}
+		return nil, s.authService.auditFailure(ctx, client, event, ErrQRCodeValidationFailed)
 	}
 
-	user, err := s.userStorage.GetUserByID(qrCode.UserID)
+	user, err := s.userStorage.GetUserByID(ctx, qrCode.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("user not found: %w", err)
+		return nil, s.authService.auditFailure(ctx, client, event, fmt.Errorf("user not found: %w", err))
 	}
 
-	tokenPair, err := s.authService.generateTokenPair(user)
+	tokenPair, err := s.authService.generateTokenPair(ctx, user, tokenGrant{
+		scope:    scope,
+		audience: []string{s.authService.cfg().AccessTokenAudience},
+		jkt:      dpopJKT,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate tokens: %w", err)
+		return nil, s.authService.auditFailure(ctx, client, event, fmt.Errorf("failed to generate tokens: %w", err))
 	}
 
+	event.SessionID = tokenPair.TokenFamily
+	s.authService.audit(ctx, client, event)
 	return tokenPair, nil
 }
 
-func (s *QRCodeService) GetDatabaseView() (*models.DatabaseView, error) {
-	users, err := s.userStorage.ListUsers()
+func (s *QRCodeService) GetDatabaseView(ctx context.Context) (*models.DatabaseView, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.GetDatabaseView")
+	defer span.End()
+
+	users, err := s.userStorage.ListUsers(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get users: %w", err)
 	}
 
-	tokens, err := s.tokenStorage.GetAllTokens()
+	tokens, err := s.tokenStorage.GetAllTokens(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tokens: %w", err)
 	}
 
-	qrCodes, err := s.qrStorage.GetAllQRCodes()
+	qrCodes, err := s.qrStorage.GetAllQRCodes(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get QR codes: %w", err)
 	}
 
-	now := time.Now()
-	stats := models.DatabaseStats{
-		TotalUsers:     len(users),
-		ActiveTokens:   0,
-		RevokedTokens:  0,
-		ExpiredTokens:  0,
-		ActiveQRCodes:  0,
-		UsedQRCodes:    0,
-		ExpiredQRCodes: 0,
-	}
-
-	for _, token := range tokens {
-		if token.IsRevoked {
-			stats.RevokedTokens++
-		} else if now.After(token.ExpiresAt) {
-			stats.ExpiredTokens++
-		} else {
-			stats.ActiveTokens++
-		}
+	tokenStats, err := s.tokenStorage.Stats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token stats: %w", err)
 	}
 
-	for _, qrCode := range qrCodes {
-		if qrCode.IsUsed {
-			stats.UsedQRCodes++
-		} else if now.After(qrCode.ExpiresAt) {
-			stats.ExpiredQRCodes++
-		} else {
-			stats.ActiveQRCodes++
-		}
+	qrStats, err := s.qrStorage.Stats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get QR code stats: %w", err)
+	}
+
+	stats := models.DatabaseStats{
+		TotalUsers:        len(users),
+		ActiveTokens:      tokenStats.Active,
+		RevokedTokens:     tokenStats.Revoked,
+		ExpiredTokens:     tokenStats.Expired,
+		ActiveQRCodes:     qrStats.Active,
+		UsedQRCodes:       qrStats.Used,
+		ExpiredQRCodes:    qrStats.Expired,
+		BindingViolations: qrStats.BindingViolations,
 	}
 
 	return &models.DatabaseView{
@@ -166,10 +319,13 @@ func (s *QRCodeService) GetDatabaseView() (*models.DatabaseView, error) {
 		Tokens:    tokens,
 		QRCodes:   qrCodes,
 		Stats:     stats,
-		Timestamp: now,
+		Timestamp: s.authService.clock.Now(),
 	}, nil
 }
 
-func (s *QRCodeService) CleanupExpiredQRCodes() error {
-	return s.qrStorage.CleanupExpiredQRCodes()
+func (s *QRCodeService) CleanupExpiredQRCodes(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "QRCodeService.CleanupExpiredQRCodes")
+	defer span.End()
+
+	return s.qrStorage.CleanupExpiredQRCodes(ctx)
 }
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/storage"
+	"rotate-token-demo/internal/tracing"
+)
+
+var ErrUserCodeLocked = errors.New("too many failed user code attempts")
+
+// User codes have only 20^8 combinations, so guessing is throttled on two axes:
+// failures per client (a wrong code cannot be attributed to any QR record) and
+// failures per code (a right code entered from the wrong place). A locked code is
+// refused until its lockout ends but is not revoked, so someone who saw it cannot
+// destroy it for its owner.
+
+const maxUserCodeCollisions = 5
+
+// RedeemUserCode is the typed counterpart of ValidateQRCode for devices that
+// cannot scan the QR image.
+func (s *QRCodeService) RedeemUserCode(ctx context.Context, req *models.QRUserCodeRequest, client models.ClientInfo, dpopJKT string) (*models.TokenPair, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.RedeemUserCode")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditQRRedeem}
+
+	clientKey := "client:" + client.IPAddress
+	if s.isLocked(ctx, clientKey) {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrUserCodeLocked)
+	}
+
+	qrCode, err := s.qrStorage.GetQRCodeByUserCode(ctx, normalizeUserCode(req.UserCode))
+	if err != nil || qrCode.Type != models.QRCodeTypeLogin {
+		return nil, s.authService.auditFailure(ctx, client, event, s.userCodeFailure(ctx, client, clientKey, ErrInvalidUserCode))
+	}
+	event.Subject = qrCode.ID
+	if s.isLocked(ctx, "code:"+qrCode.ID) {
+		return nil, s.authService.auditFailure(ctx, client, event, ErrUserCodeLocked)
+	}
+
+	scope, err := resolveScope(req.Scope, s.authService.cfg().DefaultScopes, s.authService.cfg().SupportedScopes)
+	if err != nil {
+		return nil, s.authService.auditFailure(ctx, client, event, err)
+	}
+
+	tokenPair, err := s.redeemLoginQRCode(ctx, qrCode, client, scope, dpopJKT, event)
+	if err != nil {
+		if err == ErrQRBindingViolation {
+			codeKey := "code:" + qrCode.ID
+			if s.registerFailure(ctx, codeKey, s.authService.cfg().UserCodeMaxAttemptsPerCode) {
+				s.authService.audit(ctx, client, models.AuditEvent{
+					Type:    models.AuditUserCodeLocked,
+					ActorID: qrCode.UserID,
+					Subject: qrCode.ID,
+					Outcome: models.AuditOutcomeFailure,
+					Reason:  "code_locked",
+				})
+			}
+			return nil, s.userCodeFailure(ctx, client, clientKey, err)
+		}
+		return nil, err
+	}
+
+	// Failures are not reset on success: they age out with the window, so
+	// redeeming a code of one's own between guesses does not lift the limit.
+	return tokenPair, nil
+}
+
+func (s *QRCodeService) userCodeFailure(ctx context.Context, client models.ClientInfo, key string, err error) error {
+	if s.registerFailure(ctx, key, s.authService.cfg().UserCodeMaxFailures) {
+		s.authService.audit(ctx, client, models.AuditEvent{
+			Type:    models.AuditUserCodeLocked,
+			Subject: key,
+			Outcome: models.AuditOutcomeFailure,
+			Reason:  "too_many_failures",
+		})
+		return ErrUserCodeLocked
+	}
+	return err
+}
+
+// registerFailure records a failure for key and reports whether it is now locked.
+func (s *QRCodeService) registerFailure(ctx context.Context, key string, maxFailures int) bool {
+	cfg := s.authService.cfg()
+	lockedUntil, err := s.attemptStorage.RegisterFailure(ctx, key, cfg.UserCodeFailureWindow, maxFailures, cfg.UserCodeLockout)
+	return err == nil && s.authService.clock.Now().Before(lockedUntil)
+}
+
+func (s *QRCodeService) isLocked(ctx context.Context, key string) bool {
+	lockedUntil, err := s.attemptStorage.LockedUntil(ctx, key)
+	return err == nil && s.authService.clock.Now().Before(lockedUntil)
+}
+
+// createWithUserCode stores qrCode under a freshly drawn user code. Uniqueness is
+// enforced by the storage insert, so a collision just means drawing again.
+func (s *QRCodeService) createWithUserCode(ctx context.Context, qrCode *models.QRCode) error {
+	for i := 0; i < maxUserCodeCollisions; i++ {
+		userCode, err := generateUserCode()
+		if err != nil {
+			return err
+		}
+		qrCode.UserCode = userCode
+		if err := s.qrStorage.CreateQRCode(ctx, qrCode); err != storage.ErrUserCodeTaken {
+			return err
+		}
+	}
+	return ErrQRCodeGenerationFailed
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"rotate-token-demo/internal/clock/clocktest"
+	"rotate-token-demo/internal/config"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/storage"
+	"testing"
+	"time"
+)
+
+type testQRCodeService struct {
+	*QRCodeService
+	qrCodes *storage.InMemoryQRCodeStorage
+	clock   *clocktest.Fake
+	userID  string
+}
+
+// newTestQRCodeService builds a QR code service over in-memory storages with a
+// registered user alice.
+func newTestQRCodeService(t *testing.T, live *config.Live) *testQRCodeService {
+	t.Helper()
+
+	authService, clk := newTestAuthServiceWithConfig(t, live)
+	qrCodes := storage.NewInMemoryQRCodeStorage(clk)
+	attempts := storage.NewInMemoryAttemptStorage(clk)
+	t.Cleanup(func() {
+		qrCodes.Close()
+		attempts.Close()
+	})
+
+	user, err := authService.Register(context.Background(), &models.RegisterRequest{
+		Username: "alice",
+		Email:    "alice@example.com",
+		Password: "password123",
+	}, models.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewQRCodeService(qrCodes, authService.userStorage, authService.tokenStorage, attempts, authService)
+	return &testQRCodeService{QRCodeService: s, qrCodes: qrCodes, clock: clk, userID: user.ID}
+}
+
+func (s *testQRCodeService) newUserCode(t *testing.T, binding *models.QRBindingPolicy) *models.QRCodeResponse {
+	t.Helper()
+
+	resp, err := s.GenerateQRCode(context.Background(), s.userID, &models.QRCodeGenerateRequest{
+		UserCode: true,
+		Binding:  binding,
+	}, models.ClientInfo{IPAddress: "192.0.2.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func (s *testQRCodeService) redeem(userCode, ip string) error {
+	_, err := s.RedeemUserCode(context.Background(), &models.QRUserCodeRequest{UserCode: userCode}, models.ClientInfo{IPAddress: ip}, "")
+	return err
+}
+
+func TestUserCodeThrottleFollowsReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("user_code_max_failures: 5\n")
+
+	args := []string{"-config", path}
+	cfg, err := config.Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := config.NewLive(cfg, args)
+	s := newTestQRCodeService(t, live)
+
+	if err := s.redeem("BCDFGHJK", "192.0.2.1"); err != ErrInvalidUserCode {
+		t.Fatalf("first guess: got %v, want %v", err, ErrInvalidUserCode)
+	}
+
+	writeConfig("user_code_max_failures: 2\n")
+	result, err := live.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Applied) != 1 || result.Applied[0] != "user_code_max_failures" {
+		t.Fatalf("reload applied %v, want [user_code_max_failures]", result.Applied)
+	}
+
+	// The second failure reaches the reloaded limit without a restart.
+	if err := s.redeem("BCDFGHJK", "192.0.2.1"); err != ErrUserCodeLocked {
+		t.Errorf("second guess after reload: got %v, want %v", err, ErrUserCodeLocked)
+	}
+	if err := s.redeem("BCDFGHJK", "192.0.2.1"); err != ErrUserCodeLocked {
+		t.Errorf("guess while locked: got %v, want %v", err, ErrUserCodeLocked)
+	}
+}
+
+func TestRedeemingOwnCodeDoesNotResetGuessCount(t *testing.T) {
+	cfg := config.New()
+	cfg.UserCodeMaxFailures = 3
+	s := newTestQRCodeService(t, config.NewLive(cfg, nil))
+	const ip = "192.0.2.1"
+
+	for i := 0; i < 2; i++ {
+		if err := s.redeem("BCDFGHJK", ip); err != ErrInvalidUserCode {
+			t.Fatalf("guess %d: got %v, want %v", i+1, err, ErrInvalidUserCode)
+		}
+	}
+	if err := s.redeem(s.newUserCode(t, nil).UserCode, ip); err != nil {
+		t.Fatalf("redeeming own code: %v", err)
+	}
+	if err := s.redeem("BCDFGHJK", ip); err != ErrUserCodeLocked {
+		t.Errorf("third guess: got %v, want %v", err, ErrUserCodeLocked)
+	}
+}
+
+func TestVerifyingOwnDeviceCodeDoesNotResetGuessCount(t *testing.T) {
+	cfg := config.New()
+	cfg.UserCodeMaxFailures = 3
+	s := newTestQRCodeService(t, config.NewLive(cfg, nil))
+	ctx := context.Background()
+	client := models.ClientInfo{IPAddress: "192.0.2.1"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.VerifyDeviceCode(ctx, s.userID, "BCDFGHJK", false, client); err != ErrInvalidUserCode {
+			t.Fatalf("guess %d: got %v, want %v", i+1, err, ErrInvalidUserCode)
+		}
+	}
+	device, err := s.StartDeviceAuthorization(ctx, &models.DeviceAuthorizationRequest{ClientID: "tv"}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.VerifyDeviceCode(ctx, s.userID, device.UserCode, false, client); err != nil {
+		t.Fatalf("verifying own device code: %v", err)
+	}
+	if _, err := s.VerifyDeviceCode(ctx, s.userID, "BCDFGHJK", false, client); err != ErrUserCodeLocked {
+		t.Errorf("third guess: got %v, want %v", err, ErrUserCodeLocked)
+	}
+}
+
+func TestBindingViolationsLockCodeWithoutRevokingIt(t *testing.T) {
+	cfg := config.New()
+	cfg.UserCodeMaxAttemptsPerCode = 2
+	cfg.UserCodeLockout = time.Minute
+	s := newTestQRCodeService(t, config.NewLive(cfg, nil))
+	code := s.newUserCode(t, &models.QRBindingPolicy{AllowedIPs: []string{"192.0.2.0/24"}})
+
+	for i := 0; i < 2; i++ {
+		if err := s.redeem(code.UserCode, "198.51.100.1"); err != ErrQRBindingViolation {
+			t.Fatalf("attempt %d from outside: got %v, want %v", i+1, err, ErrQRBindingViolation)
+		}
+	}
+
+	if _, err := s.qrCodes.GetQRCode(context.Background(), code.ID); err != nil {
+		t.Fatalf("locked code was revoked: %v", err)
+	}
+	if err := s.redeem(code.UserCode, "192.0.2.20"); err != ErrUserCodeLocked {
+		t.Errorf("owner during lockout: got %v, want %v", err, ErrUserCodeLocked)
+	}
+
+	s.clock.Advance(time.Minute + time.Second)
+	if err := s.redeem(code.UserCode, "192.0.2.20"); err != nil {
+		t.Errorf("owner after lockout: %v", err)
+	}
+}
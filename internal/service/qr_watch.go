@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/storage"
+	"rotate-token-demo/internal/tracing"
+	"time"
+)
+
+// QRCodeWatch is a live subscription to one QR code's state transitions.
+type QRCodeWatch struct {
+	Current   models.QRCodeEvent
+	ExpiresAt time.Time
+	Events    <-chan models.QRCodeEvent
+	Cancel    func()
+}
+
+// WatchQRCode subscribes to a QR code and reports its current status. Only status
+// transitions are exposed, never tokens or user identity; cross-device logins and
+// approvals additionally require the poll token handed to the waiting client.
+func (s *QRCodeService) WatchQRCode(ctx context.Context, id, pollToken string) (*QRCodeWatch, error) {
+	ctx, span := tracing.Start(ctx, "QRCodeService.WatchQRCode")
+	defer span.End()
+
+	// Subscribe before reading the snapshot so no transition can slip in between.
+	events, cancel := s.qrStorage.Subscribe(id)
+
+	qrCode, err := s.qrStorage.GetQRCode(ctx, id)
+	if err != nil {
+		cancel()
+		return nil, storage.ErrQRCodeNotFound
+	}
+
+	if (qrCode.Type == models.QRCodeTypeCrossDevice || qrCode.Type == models.QRCodeTypeApproval) &&
+		subtle.ConstantTimeCompare([]byte(qrCode.PollTokenHash), []byte(hashPollToken(pollToken))) != 1 {
+		cancel()
+		return nil, storage.ErrQRCodeNotFound
+	}
+
+	now := s.authService.clock.Now()
+	return &QRCodeWatch{
+		Current: models.QRCodeEvent{
+			QRCodeID:  qrCode.ID,
+			Status:    storage.CurrentQRCodeStatus(qrCode, now),
+			Timestamp: now,
+		},
+		ExpiresAt: qrCode.ExpiresAt,
+		Events:    events,
+		Cancel:    cancel,
+	}, nil
+}
+
+// IsTerminalQRCodeStatus reports whether no further transitions can follow status.
+func IsTerminalQRCodeStatus(status string) bool {
+	switch status {
+	case models.QRCodeStatusUsed, models.QRCodeStatusDenied, models.QRCodeStatusExpired, models.QRCodeStatusRevoked:
+		return true
+	}
+	return false
+}
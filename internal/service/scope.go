@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"rotate-token-demo/internal/models"
+	"strings"
+)
+
+var ErrInvalidScope = errors.New("invalid_scope")
+
+// ParseScope splits a space-delimited OAuth scope string (RFC 6749 section 3.3).
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// HasScope reports whether every required scope is present in the granted scope string.
+func HasScope(granted string, required ...string) bool {
+	grantedSet := make(map[string]bool)
+	for _, s := range ParseScope(granted) {
+		grantedSet[s] = true
+	}
+
+	for _, s := range required {
+		if !grantedSet[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// scopeRoles lists the scopes that are only granted to users holding a role.
+var scopeRoles = map[string]string{
+	"admin": models.RoleAdmin,
+}
+
+// resolveScope returns the scope to grant for a request. An empty request gets
+// defaults; otherwise each requested scope must appear in allowed.
+func resolveScope(requested string, defaults, allowed []string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(defaults, " "), nil
+	}
+
+	allowedScope := strings.Join(allowed, " ")
+	var granted []string
+	seen := make(map[string]bool)
+	for _, s := range ParseScope(requested) {
+		if !HasScope(allowedScope, s) {
+			return "", ErrInvalidScope
+		}
+		if !seen[s] {
+			seen[s] = true
+			granted = append(granted, s)
+		}
+	}
+
+	return strings.Join(granted, " "), nil
+}
+
+// grantableScopes filters supported down to the scopes user may hold.
+func grantableScopes(user *models.User, supported []string) []string {
+	var scopes []string
+	for _, scope := range supported {
+		if userMayHold(user, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// userMayHold reports whether user has the role every scope in scope requires.
+func userMayHold(user *models.User, scope string) bool {
+	for _, s := range ParseScope(scope) {
+		if role, ok := scopeRoles[s]; ok && !user.HasRole(role) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUserScope fails with ErrInvalidScope when userID may not hold scope.
+// Flows where the device asks for a scope before anyone signs in call it when
+// the user consents.
+func (s *QRCodeService) checkUserScope(ctx context.Context, userID, scope string) error {
+	user, err := s.userStorage.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !userMayHold(user, scope) {
+		return ErrInvalidScope
+	}
+	return nil
+}
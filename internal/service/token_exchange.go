@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/tracing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// RFC 8693 token type identifiers. Only JWT access tokens issued by this service
+// can be exchanged.
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+var (
+	ErrUnsupportedTokenType = errors.New("unsupported token type")
+	ErrInvalidTarget        = errors.New("invalid_target")
+	ErrUnauthorizedClient   = errors.New("unauthorized_client")
+	ErrInvalidClient        = errors.New("invalid_client")
+)
+
+// ExchangeToken issues a downscoped, audience-restricted access token on behalf of the
+// subject. When an actor token is supplied the new token carries an "act" claim
+// naming the actor, nested on top of any delegation already present in the subject.
+// A DPoP-bound subject token can only be exchanged by the holder of its key, and
+// the issued token stays bound to that key. The caller must authenticate as a
+// client with a secret from client_secrets, and each audience can only be
+// requested by the clients listed for it in exchange_audiences.
+func (s *AuthService) ExchangeToken(ctx context.Context, req *models.OAuthTokenRequest, client models.ClientInfo, dpopJKT string) (*models.TokenExchangeResponse, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.ExchangeToken")
+	defer span.End()
+
+	event := models.AuditEvent{Type: models.AuditTokenExchange}
+
+	if !isExchangeableTokenType(req.SubjectTokenType) {
+		return nil, s.auditFailure(ctx, client, event, ErrUnsupportedTokenType)
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != TokenTypeAccessToken {
+		return nil, s.auditFailure(ctx, client, event, ErrUnsupportedTokenType)
+	}
+
+	if !s.authenticateClient(req.ClientID, req.ClientSecret) {
+		return nil, s.auditFailure(ctx, client, event, ErrInvalidClient)
+	}
+
+	audience := req.Audience
+	if audience == "" {
+		audience = req.Resource
+	}
+	event.Subject = audience
+	clients, ok := s.cfg().ExchangeAudiences[audience]
+	if audience == "" || !ok {
+		return nil, s.auditFailure(ctx, client, event, ErrInvalidTarget)
+	}
+	if !clientAllowed(clients, req.ClientID) {
+		return nil, s.auditFailure(ctx, client, event, ErrUnauthorizedClient)
+	}
+
+	subject, err := s.ValidateAccessToken(ctx, req.SubjectToken)
+	if err != nil {
+		return nil, s.auditFailure(ctx, client, event, err)
+	}
+	event.ActorID = subject.UserID
+
+	if err := s.CheckTokenBinding(subject, dpopJKT); err != nil {
+		return nil, s.auditFailure(ctx, client, event, err)
+	}
+
+	// Scope yalnızca daraltılabilir: istenen her scope, subject token'da zaten bulunmalı
+	granted := ParseScope(subject.Scope)
+	scope, err := resolveScope(req.Scope, granted, granted)
+	if err != nil {
+		return nil, s.auditFailure(ctx, client, event, err)
+	}
+
+	act := subject.Act
+	if req.ActorToken != "" {
+		if !isExchangeableTokenType(req.ActorTokenType) {
+			return nil, s.auditFailure(ctx, client, event, ErrUnsupportedTokenType)
+		}
+		actor, err := s.ValidateAccessToken(ctx, req.ActorToken)
+		if err != nil {
+			return nil, s.auditFailure(ctx, client, event, err)
+		}
+		act = &models.ActorClaims{
+			Subject: actor.Subject,
+			Act:     subject.Act,
+		}
+	}
+
+	now := s.clock.Now()
+	expiresAt := now.Add(s.cfg().TokenExchangeExpiry)
+	if subject.ExpiresAt != nil && subject.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = subject.ExpiresAt.Time
+	}
+
+	claims := &models.Claims{
+		UserID:   subject.UserID,
+		Username: subject.Username,
+		Email:    subject.Email,
+		Scope:    scope,
+		Act:      act,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "rotate-token-demo",
+			Subject:   subject.Subject,
+			Audience:  jwt.ClaimStrings{audience},
+			ID:        uuid.New().String(),
+		},
+	}
+	tokenType := "Bearer"
+	if dpopJKT != "" {
+		claims.Cnf = &models.Confirmation{JKT: dpopJKT}
+		tokenType = "DPoP"
+	}
+
+	tokenString, err := s.signClaims(ctx, claims)
+	if err != nil {
+		return nil, s.auditFailure(ctx, client, event, err)
+	}
+
+	s.audit(ctx, client, event)
+	return &models.TokenExchangeResponse{
+		AccessToken:     tokenString,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       tokenType,
+		ExpiresIn:       int64(expiresAt.Sub(now).Seconds()),
+		Scope:           scope,
+	}, nil
+}
+
+func isExchangeableTokenType(tokenType string) bool {
+	return tokenType == TokenTypeAccessToken || tokenType == TokenTypeJWT
+}
+
+// authenticateClient checks a client secret against every secret configured for
+// clientID. Secrets are compared by hash so the comparison time reveals
+// neither their content nor their length.
+func (s *AuthService) authenticateClient(clientID, secret string) bool {
+	if clientID == "" || secret == "" {
+		return false
+	}
+	presented := sha256.Sum256([]byte(secret))
+	authenticated := 0
+	for _, configured := range s.cfg().ClientSecrets[clientID] {
+		expected := sha256.Sum256([]byte(configured))
+		authenticated |= subtle.ConstantTimeCompare(presented[:], expected[:])
+	}
+	return authenticated == 1
+}
+
+func clientAllowed(clients []string, clientID string) bool {
+	for _, c := range clients {
+		if clientID != "" && c == clientID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"rotate-token-demo/internal/clock"
+	"sync"
+	"time"
+)
+
+// AttemptStorage counts failed guesses per key (a client address, a user or a
+// single code) within a sliding window and locks the key once too many pile up.
+type AttemptStorage interface {
+	RegisterFailure(ctx context.Context, key string, window time.Duration, maxFailures int, lockout time.Duration) (lockedUntil time.Time, err error)
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	ResetFailures(ctx context.Context, key string) error
+	CleanupExpiredAttempts(ctx context.Context) error
+}
+
+type attemptRecord struct {
+	failures    int
+	windowEnd   time.Time
+	lockedUntil time.Time
+}
+
+type InMemoryAttemptStorage struct {
+	mu       sync.Mutex
+	attempts map[string]*attemptRecord
+	cleanup  *cleanupLoop
+	clock    clock.Clock
+}
+
+func NewInMemoryAttemptStorage(clk clock.Clock) *InMemoryAttemptStorage {
+	storage := &InMemoryAttemptStorage{
+		attempts: make(map[string]*attemptRecord),
+		cleanup:  newCleanupLoop(1*time.Minute, clk),
+		clock:    clk,
+	}
+
+	storage.cleanup.start(storage.CleanupExpiredAttempts)
+
+	return storage
+}
+
+// RegisterFailure records one failure for key and returns the time until which
+// the key is locked, or the zero time if it is not.
+func (s *InMemoryAttemptStorage) RegisterFailure(ctx context.Context, key string, window time.Duration, maxFailures int, lockout time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	record, exists := s.attempts[key]
+	if !exists || now.After(record.windowEnd) {
+		record = &attemptRecord{windowEnd: now.Add(window), lockedUntil: lockedUntil(record)}
+		s.attempts[key] = record
+	}
+
+	record.failures++
+	if record.failures >= maxFailures {
+		record.lockedUntil = now.Add(lockout)
+		record.failures = 0
+		record.windowEnd = record.lockedUntil
+	}
+
+	if now.Before(record.lockedUntil) {
+		return record.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (s *InMemoryAttemptStorage) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, exists := s.attempts[key]
+	if !exists || !s.clock.Now().Before(record.lockedUntil) {
+		return time.Time{}, nil
+	}
+	return record.lockedUntil, nil
+}
+
+func (s *InMemoryAttemptStorage) ResetFailures(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *InMemoryAttemptStorage) CleanupExpiredAttempts(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for key, record := range s.attempts {
+		if now.After(record.windowEnd) && now.After(record.lockedUntil) {
+			delete(s.attempts, key)
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryAttemptStorage) CheckHealth(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return nil
+}
+
+func (s *InMemoryAttemptStorage) CheckCleanup() error {
+	return s.cleanup.check()
+}
+
+// Close stops the background cleanup loop.
+func (s *InMemoryAttemptStorage) Close() error {
+	s.cleanup.stop()
+	return nil
+}
+
+func lockedUntil(record *attemptRecord) time.Time {
+	if record == nil {
+		return time.Time{}
+	}
+	return record.lockedUntil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"rotate-token-demo/internal/models"
+	"sync"
+)
+
+const defaultAuditQueryLimit = 100
+
+// AuditLog is an append-only record of authentication events. Query returns the
+// newest matching events first.
+type AuditLog interface {
+	Record(ctx context.Context, event *models.AuditEvent) error
+	Query(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error)
+}
+
+// InMemoryAuditLog keeps the most recent events in a bounded ring buffer.
+type InMemoryAuditLog struct {
+	mu     sync.RWMutex
+	events []*models.AuditEvent
+	next   int
+	full   bool
+}
+
+func NewInMemoryAuditLog(capacity int) *InMemoryAuditLog {
+	return &InMemoryAuditLog{
+		events: make([]*models.AuditEvent, capacity),
+	}
+}
+
+func (l *InMemoryAuditLog) CheckHealth(ctx context.Context) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return nil
+}
+
+func (l *InMemoryAuditLog) Record(ctx context.Context, event *models.AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.events) == 0 {
+		return nil
+	}
+
+	stored := *event
+	l.events[l.next] = &stored
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+	return nil
+}
+
+func (l *InMemoryAuditLog) Query(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.events)
+	}
+
+	limit := auditQueryLimit(filter)
+	var events []*models.AuditEvent
+	for i := 1; i <= count && len(events) < limit; i++ {
+		event := l.events[(l.next-i+len(l.events))%len(l.events)]
+		if matchesAuditFilter(event, filter) {
+			copied := *event
+			events = append(events, &copied)
+		}
+	}
+
+	return events, nil
+}
+
+func matchesAuditFilter(event *models.AuditEvent, filter models.AuditFilter) bool {
+	if filter.ActorID != "" && event.ActorID != filter.ActorID {
+		return false
+	}
+	if filter.RequestID != "" && event.RequestID != filter.RequestID {
+		return false
+	}
+	if filter.Type != "" && event.Type != filter.Type {
+		return false
+	}
+	if !filter.From.IsZero() && event.Timestamp.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && event.Timestamp.After(filter.To) {
+		return false
+	}
+	return true
+}
+
+func auditQueryLimit(filter models.AuditFilter) int {
+	if filter.Limit <= 0 {
+		return defaultAuditQueryLimit
+	}
+	return filter.Limit
+}
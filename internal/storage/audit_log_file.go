@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"rotate-token-demo/internal/models"
+	"sync"
+)
+
+// JSONLinesAuditLog appends one JSON object per event to a file, which suits log
+// shippers. Queries scan the whole file and are meant for occasional admin use;
+// they read through their own handle, so a slow scan does not hold up Record.
+type JSONLinesAuditLog struct {
+	// mu serializes writes; each event is written whole while it is held.
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewJSONLinesAuditLog(path string) (*JSONLinesAuditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONLinesAuditLog{
+		path: path,
+		file: file,
+	}, nil
+}
+
+// CheckHealth fails when the file was closed, or removed or replaced under the
+// open handle, in which case new events would no longer reach the path.
+func (l *JSONLinesAuditLog) CheckHealth(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	open, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	current, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(open, current) {
+		return fmt.Errorf("%s was replaced since it was opened", l.path)
+	}
+	return nil
+}
+
+func (l *JSONLinesAuditLog) Record(ctx context.Context, event *models.AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+func (l *JSONLinesAuditLog) Query(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	// Only events written before the query started are read: the size taken
+	// under mu ends on a line boundary, and later appends are left alone.
+	l.mu.Lock()
+	info, err := l.file.Stat()
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var matched []*models.AuditEvent
+	err = readAuditLines(io.LimitReader(file, info.Size()), func(line []byte) {
+		var event models.AuditEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return
+		}
+		if matchesAuditFilter(&event, filter) {
+			matched = append(matched, &event)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The file is in chronological order; return the newest events first.
+	limit := auditQueryLimit(filter)
+	events := make([]*models.AuditEvent, 0, limit)
+	for i := len(matched) - 1; i >= 0 && len(events) < limit; i-- {
+		events = append(events, matched[i])
+	}
+
+	return events, nil
+}
+
+// maxAuditLineBytes bounds the memory a query spends on one line. Longer lines
+// cannot come from AuthService, which truncates client-supplied fields, and are
+// skipped rather than failing every later query.
+const maxAuditLineBytes = 1 << 20
+
+// readAuditLines calls fn for each line of r, skipping lines longer than
+// maxAuditLineBytes.
+func readAuditLines(r io.Reader, fn func(line []byte)) error {
+	reader := bufio.NewReaderSize(r, maxAuditLineBytes)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			for err == bufio.ErrBufferFull {
+				_, err = reader.ReadSlice('\n')
+			}
+		} else if len(line) > 0 {
+			fn(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (l *JSONLinesAuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
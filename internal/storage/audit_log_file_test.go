@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"rotate-token-demo/internal/models"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestJSONLinesAuditLogQueryDuringRecord(t *testing.T) {
+	l, err := NewJSONLinesAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ctx := context.Background()
+
+	const events = 500
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < events; i++ {
+			if err := l.Record(ctx, &models.AuditEvent{ID: fmt.Sprintf("event-%d", i), Type: "login", Outcome: "success"}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Every query sees a prefix of the log: whole events, newest first.
+	for i := 0; i < 50; i++ {
+		got, err := l.Query(ctx, models.AuditFilter{Limit: events})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, event := range got {
+			if want := fmt.Sprintf("event-%d", len(got)-1-j); event.ID != want {
+				t.Fatalf("query %d: event %d is %q, want %q", i, j, event.ID, want)
+			}
+		}
+	}
+	wg.Wait()
+
+	got, err := l.Query(ctx, models.AuditFilter{Limit: events})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != events {
+		t.Errorf("got %d events, want %d", len(got), events)
+	}
+}
+
+func TestJSONLinesAuditLogSkipsOversizedLine(t *testing.T) {
+	l, err := NewJSONLinesAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ctx := context.Background()
+
+	for _, event := range []*models.AuditEvent{
+		{ID: "before", Type: "login", Outcome: "success"},
+		{ID: "huge", Type: "login", Outcome: "failure", UserAgent: strings.Repeat("x", 2*maxAuditLineBytes)},
+		{ID: "after", Type: "login", Outcome: "success"},
+	} {
+		if err := l.Record(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := l.Query(ctx, models.AuditFilter{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != "after" || got[1].ID != "before" {
+		ids := make([]string, len(got))
+		for i, event := range got {
+			ids[i] = event.ID
+		}
+		t.Errorf("got events %v, want [after before]", ids)
+	}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rotate-token-demo/internal/models"
+	"strconv"
+	"strings"
+)
+
+// SQLAuditLog stores events in an audit_events table through database/sql. The
+// driver is registered by the caller; placeholders follow the given dialect
+// ("postgres" uses $1, $2, ...; anything else uses ?).
+type SQLAuditLog struct {
+	db      *sql.DB
+	dialect string
+}
+
+const createAuditEventsTable = `CREATE TABLE IF NOT EXISTS audit_events (
+	id         VARCHAR(36)  PRIMARY KEY,
+	type       VARCHAR(64)  NOT NULL,
+	timestamp  TIMESTAMP    NOT NULL,
+	actor_id   VARCHAR(64),
+	session_id VARCHAR(64),
+	subject    VARCHAR(255),
+	ip_address VARCHAR(64),
+	user_agent VARCHAR(512),
+	outcome    VARCHAR(16)  NOT NULL,
+	reason     VARCHAR(255),
+	request_id VARCHAR(128)
+)`
+
+func NewSQLAuditLog(db *sql.DB, dialect string) (*SQLAuditLog, error) {
+	if _, err := db.Exec(createAuditEventsTable); err != nil {
+		return nil, err
+	}
+
+	return &SQLAuditLog{
+		db:      db,
+		dialect: dialect,
+	}, nil
+}
+
+func (l *SQLAuditLog) CheckHealth(ctx context.Context) error {
+	return l.db.PingContext(ctx)
+}
+
+// Close closes the database handle passed to NewSQLAuditLog.
+func (l *SQLAuditLog) Close() error {
+	return l.db.Close()
+}
+
+func (l *SQLAuditLog) Record(ctx context.Context, event *models.AuditEvent) error {
+	query := "INSERT INTO audit_events (id, type, timestamp, actor_id, session_id, subject, ip_address, user_agent, outcome, reason, request_id) VALUES (" +
+		l.placeholders(1, 11) + ")"
+
+	_, err := l.db.ExecContext(ctx, query, event.ID, event.Type, event.Timestamp.UTC(), event.ActorID, event.SessionID,
+		event.Subject, event.IPAddress, event.UserAgent, event.Outcome, event.Reason, event.RequestID)
+	return err
+}
+
+func (l *SQLAuditLog) Query(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, condition+" "+l.placeholder(len(args)))
+	}
+
+	if filter.ActorID != "" {
+		add("actor_id =", filter.ActorID)
+	}
+	if filter.RequestID != "" {
+		add("request_id =", filter.RequestID)
+	}
+	if filter.Type != "" {
+		add("type =", filter.Type)
+	}
+	if !filter.From.IsZero() {
+		add("timestamp >=", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		add("timestamp <=", filter.To.UTC())
+	}
+
+	query := "SELECT id, type, timestamp, actor_id, session_id, subject, ip_address, user_agent, outcome, reason, request_id FROM audit_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, auditQueryLimit(filter))
+	query += " ORDER BY timestamp DESC LIMIT " + l.placeholder(len(args))
+
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		var actorID, sessionID, subject, ipAddress, userAgent, reason, requestID sql.NullString
+		if err := rows.Scan(&event.ID, &event.Type, &event.Timestamp, &actorID, &sessionID,
+			&subject, &ipAddress, &userAgent, &event.Outcome, &reason, &requestID); err != nil {
+			return nil, err
+		}
+		event.ActorID = actorID.String
+		event.SessionID = sessionID.String
+		event.Subject = subject.String
+		event.IPAddress = ipAddress.String
+		event.UserAgent = userAgent.String
+		event.Reason = reason.String
+		event.RequestID = requestID.String
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+func (l *SQLAuditLog) placeholder(n int) string {
+	if l.dialect == "postgres" {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+func (l *SQLAuditLog) placeholders(from, count int) string {
+	parts := make([]string, count)
+	for i := range parts {
+		parts[i] = l.placeholder(from + i)
+	}
+	return strings.Join(parts, ", ")
+}
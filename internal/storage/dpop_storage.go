@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"rotate-token-demo/internal/clock"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDPoPProofReplayed = errors.New("DPoP proof already used")
+)
+
+// DPoPReplayStorage remembers the jti of every accepted DPoP proof until it can no
+// longer pass the freshness check, so a captured proof cannot be replayed.
+type DPoPReplayStorage interface {
+	MarkProofUsed(ctx context.Context, jti string, expiresAt time.Time) error
+	CleanupExpiredProofs(ctx context.Context) error
+}
+
+type InMemoryDPoPReplayStorage struct {
+	mu      sync.Mutex
+	proofs  map[string]time.Time
+	cleanup *cleanupLoop
+	clock   clock.Clock
+}
+
+func NewInMemoryDPoPReplayStorage(clk clock.Clock) *InMemoryDPoPReplayStorage {
+	storage := &InMemoryDPoPReplayStorage{
+		proofs:  make(map[string]time.Time),
+		cleanup: newCleanupLoop(1*time.Minute, clk),
+		clock:   clk,
+	}
+
+	storage.cleanup.start(storage.CleanupExpiredProofs)
+
+	return storage
+}
+
+func (s *InMemoryDPoPReplayStorage) MarkProofUsed(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, exists := s.proofs[jti]; exists && s.clock.Now().Before(existing) {
+		return ErrDPoPProofReplayed
+	}
+
+	s.proofs[jti] = expiresAt
+	return nil
+}
+
+func (s *InMemoryDPoPReplayStorage) CleanupExpiredProofs(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for jti, expiresAt := range s.proofs {
+		if now.After(expiresAt) {
+			delete(s.proofs, jti)
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryDPoPReplayStorage) CheckHealth(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return nil
+}
+
+func (s *InMemoryDPoPReplayStorage) CheckCleanup() error {
+	return s.cleanup.check()
+}
+
+// Close stops the background cleanup loop.
+func (s *InMemoryDPoPReplayStorage) Close() error {
+	s.cleanup.stop()
+	return nil
+}
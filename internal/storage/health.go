@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rotate-token-demo/internal/clock"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCleanupNotRunning = errors.New("cleanup loop is not running")
+	ErrCleanupStalled    = errors.New("cleanup loop stalled")
+)
+
+// HealthChecker is implemented by storages that can tell whether their backend
+// is reachable. For in-memory storages this means the store is not wedged on
+// its lock.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// CleanupChecker is implemented by storages that expire records from a
+// background loop.
+type CleanupChecker interface {
+	CheckCleanup() error
+}
+
+// cleanupLoop runs a storage's periodic cleanup until stopped and remembers
+// when it last finished, so a loop that died or hangs shows up in readiness
+// checks. The loop is paced by a wall-clock ticker; run times are read from the
+// storage's Clock, so a fake clock can make the loop look stalled.
+type cleanupLoop struct {
+	interval time.Duration
+	clock    clock.Clock
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu      sync.Mutex
+	running bool
+	lastRun time.Time
+}
+
+func newCleanupLoop(interval time.Duration, clk clock.Clock) *cleanupLoop {
+	return &cleanupLoop{interval: interval, clock: clk}
+}
+
+// start runs cleanup every interval. The context passed to cleanup is
+// cancelled by stop, which interrupts a run in progress.
+func (l *cleanupLoop) start(cleanup func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.done = make(chan struct{})
+
+	l.mu.Lock()
+	l.running = true
+	l.lastRun = l.clock.Now()
+	l.mu.Unlock()
+
+	go l.run(ctx, cleanup)
+}
+
+func (l *cleanupLoop) run(ctx context.Context, cleanup func(ctx context.Context) error) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	defer close(l.done)
+	defer func() {
+		l.mu.Lock()
+		l.running = false
+		l.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ticker.C:
+			cleanup(ctx)
+
+			l.mu.Lock()
+			l.lastRun = l.clock.Now()
+			l.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stop ends the loop and waits for it to exit. It may be called more than once.
+func (l *cleanupLoop) stop() {
+	l.cancel()
+	<-l.done
+}
+
+// check fails when the loop has exited or has missed two runs in a row.
+func (l *cleanupLoop) check() error {
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.running {
+		return ErrCleanupNotRunning
+	}
+	if since := now.Sub(l.lastRun); since > 2*l.interval {
+		return fmt.Errorf("%w: last run %s ago", ErrCleanupStalled, since.Round(time.Second))
+	}
+	return nil
+}
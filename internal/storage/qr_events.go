@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"rotate-token-demo/internal/models"
+	"sync"
+	"time"
+)
+
+// qrEventBuffer is how many undelivered transitions a slow subscriber may lag
+// behind before further events for it are dropped. QR codes only go through a
+// handful of states, so this is never reached in practice.
+const qrEventBuffer = 8
+
+// QRCodeEventHub fans out QR code state transitions to in-process subscribers,
+// so waiters are notified without polling storage.
+type QRCodeEventHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan models.QRCodeEvent]struct{}
+}
+
+func NewQRCodeEventHub() *QRCodeEventHub {
+	return &QRCodeEventHub{
+		subscribers: make(map[string]map[chan models.QRCodeEvent]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving every future event for the QR code and a
+// function that must be called to unsubscribe.
+func (h *QRCodeEventHub) Subscribe(qrCodeID string) (<-chan models.QRCodeEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan models.QRCodeEvent, qrEventBuffer)
+	if h.subscribers[qrCodeID] == nil {
+		h.subscribers[qrCodeID] = make(map[chan models.QRCodeEvent]struct{})
+	}
+	h.subscribers[qrCodeID][ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			delete(h.subscribers[qrCodeID], ch)
+			if len(h.subscribers[qrCodeID]) == 0 {
+				delete(h.subscribers, qrCodeID)
+			}
+		})
+	}
+}
+
+func (h *QRCodeEventHub) Publish(event models.QRCodeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers[event.QRCodeID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// CurrentQRCodeStatus derives the externally visible status of a QR code, folding
+// in redemption and expiry which are not stored in Status.
+func CurrentQRCodeStatus(qrCode *models.QRCode, now time.Time) string {
+	switch {
+	case qrCode.IsUsed:
+		return models.QRCodeStatusUsed
+	case qrCode.Status == models.QRCodeStatusDenied:
+		return models.QRCodeStatusDenied
+	case now.After(qrCode.ExpiresAt):
+		return models.QRCodeStatusExpired
+	case qrCode.Status == "":
+		return models.QRCodeStatusPending
+	}
+	return qrCode.Status
+}
+
+func newQRCodeEvent(qrCodeID, status string, now time.Time) models.QRCodeEvent {
+	return models.QRCodeEvent{
+		QRCodeID:  qrCodeID,
+		Status:    status,
+		Timestamp: now,
+	}
+}
@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
+	"rotate-token-demo/internal/clock"
 	"rotate-token-demo/internal/models"
 	"sync"
 	"time"
@@ -11,44 +15,112 @@ var (
 	ErrQRCodeNotFound = errors.New("QR code not found")
 	ErrQRCodeExpired  = errors.New("QR code expired")
 	ErrQRCodeUsed     = errors.New("QR code already used")
+	// ErrQRCodeStateChanged means the code's status is no longer the one a
+	// transition expected, because another request changed it first.
+	ErrQRCodeStateChanged = errors.New("QR code state changed")
+	// ErrUserCodeTaken means another stored code already has the user code;
+	// the caller should draw a new one and retry.
+	ErrUserCodeTaken = errors.New("user code already in use")
 )
 
+// QRCodeTransition is a compare-and-set of a code's status. UserID and
+// ScannedBy are written along with the new status when non-empty; no other
+// field is touched.
+type QRCodeTransition struct {
+	From      string
+	To        string
+	UserID    string
+	ScannedBy string
+}
+
 type QRCodeStorage interface {
-	CreateQRCode(qrCode *models.QRCode) error
-	GetQRCode(id string) (*models.QRCode, error)
-	GetQRCodeByData(data string) (*models.QRCode, error)
-	MarkQRCodeAsUsed(id string, ipAddress string) error
-	GetAllQRCodes() ([]*models.QRCode, error)
-	GetActiveQRCodes() ([]*models.QRCode, error)
-	CleanupExpiredQRCodes() error
-	DeleteQRCode(id string) error
+	CreateQRCode(ctx context.Context, qrCode *models.QRCode) error
+	GetQRCode(ctx context.Context, id string) (*models.QRCode, error)
+	GetQRCodeByData(ctx context.Context, data string) (*models.QRCode, error)
+	GetQRCodeByUserCode(ctx context.Context, userCode string) (*models.QRCode, error)
+	TransitionQRCode(ctx context.Context, id string, transition QRCodeTransition) (*models.QRCode, error)
+	RecordQRCodePoll(ctx context.Context, id string, backoff int) (*models.QRCode, bool, error)
+	MarkQRCodeAsUsed(ctx context.Context, id string, ipAddress string) error
+	ConsumeQRCode(ctx context.Context, data string, ipAddress string) (*models.QRCode, error)
+	RecordBindingViolation(ctx context.Context, id string, violation models.QRBindingViolation) error
+	GetUserQRCodes(ctx context.Context, userID string) ([]*models.QRCode, error)
+	GetAllQRCodes(ctx context.Context) ([]*models.QRCode, error)
+	GetActiveQRCodes(ctx context.Context) ([]*models.QRCode, error)
+	CleanupExpiredQRCodes(ctx context.Context) error
+	DeleteQRCode(ctx context.Context, id string) error
+	Subscribe(id string) (<-chan models.QRCodeEvent, func())
+	Stats() (QRCodeStats, error)
 }
 
+const (
+	qrStateActive  = "active"
+	qrStateUsed    = "used"
+	qrStateExpired = "expired"
+)
+
+// InMemoryQRCodeStorage keeps secondary indexes next to the primary map so that
+// payload, user code and per-user lookups do not scan every stored code. All
+// indexes are updated under the same lock as qrCodes. Stored codes are never
+// mutated in place and callers only ever receive copies, so a reader cannot
+// observe a half-applied update. State counts for Stats are maintained the same
+// way.
 type InMemoryQRCodeStorage struct {
-	mu      sync.RWMutex
-	qrCodes map[string]*models.QRCode
+	mu                sync.RWMutex
+	qrCodes           map[string]*models.QRCode
+	byData            map[[sha256.Size]byte]string
+	byUserCode        map[string]string
+	byUser            map[string]map[string]struct{}
+	states            *stateCounter
+	bindingViolations int
+	events            *QRCodeEventHub
+	cleanup           *cleanupLoop
+	clock             clock.Clock
 }
 
-func NewInMemoryQRCodeStorage() *InMemoryQRCodeStorage {
+func NewInMemoryQRCodeStorage(clk clock.Clock) *InMemoryQRCodeStorage {
 	storage := &InMemoryQRCodeStorage{
-		qrCodes: make(map[string]*models.QRCode),
+		qrCodes:    make(map[string]*models.QRCode),
+		byData:     make(map[[sha256.Size]byte]string),
+		byUserCode: make(map[string]string),
+		byUser:     make(map[string]map[string]struct{}),
+		states:     newStateCounter(),
+		events:     NewQRCodeEventHub(),
+		cleanup:    newCleanupLoop(1*time.Minute, clk),
+		clock:      clk,
 	}
 
-	// Start cleanup goroutine
-	go storage.startCleanupRoutine()
+	storage.cleanup.start(storage.CleanupExpiredQRCodes)
 
 	return storage
 }
 
-func (s *InMemoryQRCodeStorage) CreateQRCode(qrCode *models.QRCode) error {
+// CreateQRCode stores qrCode, replacing any code with the same ID. The user code
+// check and the insert happen under one lock, so two codes can never share a
+// user code.
+func (s *InMemoryQRCodeStorage) CreateQRCode(ctx context.Context, qrCode *models.QRCode) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if qrCode.UserCode != "" {
+		if id, taken := s.byUserCode[qrCode.UserCode]; taken && id != qrCode.ID {
+			return ErrUserCodeTaken
+		}
+	}
+
+	existing, exists := s.qrCodes[qrCode.ID]
+	if exists {
+		s.unindex(existing)
+	}
+	now := s.clock.Now()
+	qrCode = cloneQRCode(qrCode)
 	s.qrCodes[qrCode.ID] = qrCode
+	s.index(qrCode)
+	s.track(existing, qrCode, now)
+	s.events.Publish(newQRCodeEvent(qrCode.ID, CurrentQRCodeStatus(qrCode, now), now))
 	return nil
 }
 
-func (s *InMemoryQRCodeStorage) GetQRCode(id string) (*models.QRCode, error) {
+func (s *InMemoryQRCodeStorage) GetQRCode(ctx context.Context, id string) (*models.QRCode, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -57,23 +129,102 @@ func (s *InMemoryQRCodeStorage) GetQRCode(id string) (*models.QRCode, error) {
 		return nil, ErrQRCodeNotFound
 	}
 
-	return qrCode, nil
+	return cloneQRCode(qrCode), nil
 }
 
-func (s *InMemoryQRCodeStorage) GetQRCodeByData(data string) (*models.QRCode, error) {
+func (s *InMemoryQRCodeStorage) GetQRCodeByData(ctx context.Context, data string) (*models.QRCode, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	for _, qrCode := range s.qrCodes {
-		if qrCode.Data == data {
-			return qrCode, nil
-		}
+	qrCode, exists := s.qrCodes[s.byData[sha256.Sum256([]byte(data))]]
+	if !exists || subtle.ConstantTimeCompare([]byte(qrCode.Data), []byte(data)) != 1 {
+		return nil, ErrQRCodeNotFound
 	}
 
-	return nil, ErrQRCodeNotFound
+	return cloneQRCode(qrCode), nil
 }
 
-func (s *InMemoryQRCodeStorage) MarkQRCodeAsUsed(id string, ipAddress string) error {
+func (s *InMemoryQRCodeStorage) GetQRCodeByUserCode(ctx context.Context, userCode string) (*models.QRCode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if userCode == "" {
+		return nil, ErrQRCodeNotFound
+	}
+
+	qrCode, exists := s.qrCodes[s.byUserCode[userCode]]
+	if !exists {
+		return nil, ErrQRCodeNotFound
+	}
+
+	return cloneQRCode(qrCode), nil
+}
+
+// TransitionQRCode moves an unused, unexpired code from transition.From to
+// transition.To. It fails with ErrQRCodeStateChanged if the code has another
+// status, so a decision can neither be lost nor reversed by a concurrent one.
+func (s *InMemoryQRCodeStorage) TransitionQRCode(ctx context.Context, id string, transition QRCodeTransition) (*models.QRCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.qrCodes[id]
+	if !exists {
+		return nil, ErrQRCodeNotFound
+	}
+	if existing.IsUsed {
+		return nil, ErrQRCodeUsed
+	}
+	now := s.clock.Now()
+	if now.After(existing.ExpiresAt) {
+		return nil, ErrQRCodeExpired
+	}
+	if existing.Status != transition.From {
+		return nil, ErrQRCodeStateChanged
+	}
+
+	updated := cloneQRCode(existing)
+	updated.Status = transition.To
+	if transition.UserID != "" {
+		updated.UserID = transition.UserID
+	}
+	if transition.ScannedBy != "" {
+		updated.ScannedBy = transition.ScannedBy
+	}
+
+	s.unindex(existing)
+	s.qrCodes[id] = updated
+	s.index(updated)
+	s.track(existing, updated, now)
+	s.events.Publish(newQRCodeEvent(id, CurrentQRCodeStatus(updated, now), now))
+	return cloneQRCode(updated), nil
+}
+
+// RecordQRCodePoll stamps the time a device polled the code. A poll sooner
+// than the code's interval after the previous one adds backoff seconds to the
+// interval and reports true. Only the poll fields are written, so a poll cannot
+// undo a concurrent decision.
+func (s *InMemoryQRCodeStorage) RecordQRCodePoll(ctx context.Context, id string, backoff int) (*models.QRCode, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.qrCodes[id]
+	if !exists {
+		return nil, false, ErrQRCodeNotFound
+	}
+
+	now := s.clock.Now()
+	updated := cloneQRCode(existing)
+	tooFast := existing.LastPolledAt != nil && now.Sub(*existing.LastPolledAt) < time.Duration(existing.Interval)*time.Second
+	if tooFast {
+		updated.Interval += backoff
+	}
+	updated.LastPolledAt = &now
+
+	s.qrCodes[id] = updated
+	return cloneQRCode(updated), tooFast, nil
+}
+
+func (s *InMemoryQRCodeStorage) MarkQRCodeAsUsed(ctx context.Context, id string, ipAddress string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -82,81 +233,253 @@ func (s *InMemoryQRCodeStorage) MarkQRCodeAsUsed(id string, ipAddress string) er
 		return ErrQRCodeNotFound
 	}
 
+	_, err := s.markUsed(qrCode, ipAddress)
+	return err
+}
+
+// ConsumeQRCode looks up a code by its payload and marks it used in one critical
+// section, so of several concurrent scans of the same code exactly one succeeds.
+func (s *InMemoryQRCodeStorage) ConsumeQRCode(ctx context.Context, data string, ipAddress string) (*models.QRCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	qrCode, exists := s.qrCodes[s.byData[sha256.Sum256([]byte(data))]]
+	if !exists || subtle.ConstantTimeCompare([]byte(qrCode.Data), []byte(data)) != 1 {
+		return nil, ErrQRCodeNotFound
+	}
+
+	return s.markUsed(qrCode, ipAddress)
+}
+
+// RecordBindingViolation appends a rejected redemption attempt to the code without
+// touching any other field, so it cannot undo a concurrent consume.
+func (s *InMemoryQRCodeStorage) RecordBindingViolation(ctx context.Context, id string, violation models.QRBindingViolation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	qrCode, exists := s.qrCodes[id]
+	if !exists {
+		return ErrQRCodeNotFound
+	}
+
+	updated := cloneQRCode(qrCode)
+	updated.BindingViolations = append(updated.BindingViolations, violation)
+	s.qrCodes[id] = updated
+	s.track(qrCode, updated, s.clock.Now())
+	return nil
+}
+
+// markUsed must be called with mu held for writing.
+func (s *InMemoryQRCodeStorage) markUsed(qrCode *models.QRCode, ipAddress string) (*models.QRCode, error) {
 	if qrCode.IsUsed {
-		return ErrQRCodeUsed
+		return nil, ErrQRCodeUsed
 	}
 
-	if time.Now().After(qrCode.ExpiresAt) {
-		return ErrQRCodeExpired
+	now := s.clock.Now()
+	if now.After(qrCode.ExpiresAt) {
+		return nil, ErrQRCodeExpired
 	}
 
-	now := time.Now()
-	qrCode.IsUsed = true
-	qrCode.UsedAt = &now
-	qrCode.IPAddress = ipAddress
+	used := cloneQRCode(qrCode)
+	used.IsUsed = true
+	used.UsedAt = &now
+	used.IPAddress = ipAddress
+	s.qrCodes[used.ID] = used
+	s.track(qrCode, used, now)
+	s.events.Publish(newQRCodeEvent(used.ID, models.QRCodeStatusUsed, now))
 
-	return nil
+	return cloneQRCode(used), nil
+}
+
+func (s *InMemoryQRCodeStorage) GetUserQRCodes(ctx context.Context, userID string) ([]*models.QRCode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.byUser[userID]
+	qrCodes := make([]*models.QRCode, 0, len(ids))
+	for id := range ids {
+		qrCodes = append(qrCodes, cloneQRCode(s.qrCodes[id]))
+	}
+
+	return qrCodes, nil
 }
 
-func (s *InMemoryQRCodeStorage) GetAllQRCodes() ([]*models.QRCode, error) {
+func (s *InMemoryQRCodeStorage) GetAllQRCodes(ctx context.Context) ([]*models.QRCode, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var qrCodes []*models.QRCode
 	for _, qrCode := range s.qrCodes {
-		qrCodes = append(qrCodes, qrCode)
+		qrCodes = append(qrCodes, cloneQRCode(qrCode))
 	}
 
 	return qrCodes, nil
 }
 
-func (s *InMemoryQRCodeStorage) GetActiveQRCodes() ([]*models.QRCode, error) {
+func (s *InMemoryQRCodeStorage) GetActiveQRCodes(ctx context.Context) ([]*models.QRCode, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var activeQRCodes []*models.QRCode
-	now := time.Now()
+	now := s.clock.Now()
 
 	for _, qrCode := range s.qrCodes {
 		if !qrCode.IsUsed && now.Before(qrCode.ExpiresAt) {
-			activeQRCodes = append(activeQRCodes, qrCode)
+			activeQRCodes = append(activeQRCodes, cloneQRCode(qrCode))
 		}
 	}
 
 	return activeQRCodes, nil
 }
 
-func (s *InMemoryQRCodeStorage) CleanupExpiredQRCodes() error {
+func (s *InMemoryQRCodeStorage) CleanupExpiredQRCodes(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	now := time.Now()
+	now := s.clock.Now()
 	for id, qrCode := range s.qrCodes {
 		if now.After(qrCode.ExpiresAt) {
+			s.unindex(qrCode)
+			s.untrack(qrCode)
 			delete(s.qrCodes, id)
+			if !qrCode.IsUsed {
+				s.events.Publish(newQRCodeEvent(id, models.QRCodeStatusExpired, now))
+			}
 		}
 	}
 
 	return nil
 }
 
-func (s *InMemoryQRCodeStorage) DeleteQRCode(id string) error {
+func (s *InMemoryQRCodeStorage) DeleteQRCode(ctx context.Context, id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.qrCodes[id]; !exists {
+	qrCode, exists := s.qrCodes[id]
+	if !exists {
 		return ErrQRCodeNotFound
 	}
 
+	s.unindex(qrCode)
+	s.untrack(qrCode)
 	delete(s.qrCodes, id)
+	s.events.Publish(newQRCodeEvent(id, models.QRCodeStatusRevoked, s.clock.Now()))
 	return nil
 }
 
-func (s *InMemoryQRCodeStorage) startCleanupRoutine() {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
+func (s *InMemoryQRCodeStorage) Subscribe(id string) (<-chan models.QRCodeEvent, func()) {
+	return s.events.Subscribe(id)
+}
+
+// Stats reports stored codes by state without scanning them; codes that expired
+// since the last call are re-classified here.
+func (s *InMemoryQRCodeStorage) Stats() (QRCodeStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for _, id := range s.states.due(now) {
+		qrCode := s.qrCodes[id]
+		s.track(qrCode, qrCode, now)
+	}
+
+	return QRCodeStats{
+		Active:            s.states.count(qrStateActive),
+		Used:              s.states.count(qrStateUsed),
+		Expired:           s.states.count(qrStateExpired),
+		BindingViolations: s.bindingViolations,
+	}, nil
+}
+
+func cloneQRCode(qrCode *models.QRCode) *models.QRCode {
+	clone := *qrCode
+	if qrCode.UsedAt != nil {
+		usedAt := *qrCode.UsedAt
+		clone.UsedAt = &usedAt
+	}
+	if qrCode.LastPolledAt != nil {
+		lastPolledAt := *qrCode.LastPolledAt
+		clone.LastPolledAt = &lastPolledAt
+	}
+	if qrCode.Binding != nil {
+		binding := *qrCode.Binding
+		binding.AllowedIPs = append([]string(nil), qrCode.Binding.AllowedIPs...)
+		clone.Binding = &binding
+	}
+	clone.BindingViolations = append([]models.QRBindingViolation(nil), qrCode.BindingViolations...)
+	return &clone
+}
+
+// index and unindex must be called with mu held for writing.
+func (s *InMemoryQRCodeStorage) index(qrCode *models.QRCode) {
+	if qrCode.Data != "" {
+		s.byData[sha256.Sum256([]byte(qrCode.Data))] = qrCode.ID
+	}
+	if qrCode.UserCode != "" {
+		s.byUserCode[qrCode.UserCode] = qrCode.ID
+	}
+	if qrCode.UserID != "" {
+		ids, exists := s.byUser[qrCode.UserID]
+		if !exists {
+			ids = make(map[string]struct{})
+			s.byUser[qrCode.UserID] = ids
+		}
+		ids[qrCode.ID] = struct{}{}
+	}
+}
+
+func (s *InMemoryQRCodeStorage) unindex(qrCode *models.QRCode) {
+	if qrCode.Data != "" {
+		key := sha256.Sum256([]byte(qrCode.Data))
+		if s.byData[key] == qrCode.ID {
+			delete(s.byData, key)
+		}
+	}
+	if qrCode.UserCode != "" && s.byUserCode[qrCode.UserCode] == qrCode.ID {
+		delete(s.byUserCode, qrCode.UserCode)
+	}
+	if ids, exists := s.byUser[qrCode.UserID]; exists {
+		delete(ids, qrCode.ID)
+		if len(ids) == 0 {
+			delete(s.byUser, qrCode.UserID)
+		}
+	}
+}
+
+// track and untrack keep the state counts in step with qrCodes and must be called
+// with mu held for writing. previous is the record qrCode replaced, if any.
+func (s *InMemoryQRCodeStorage) track(previous, qrCode *models.QRCode, now time.Time) {
+	if previous != nil {
+		s.bindingViolations -= len(previous.BindingViolations)
+	}
+	s.bindingViolations += len(qrCode.BindingViolations)
 
-	for range ticker.C {
-		s.CleanupExpiredQRCodes()
+	state := qrStateActive
+	if qrCode.IsUsed {
+		state = qrStateUsed
+	} else if now.After(qrCode.ExpiresAt) {
+		state = qrStateExpired
 	}
+	s.states.track(qrCode.ID, state, qrCode.ExpiresAt)
+}
+
+func (s *InMemoryQRCodeStorage) untrack(qrCode *models.QRCode) {
+	s.bindingViolations -= len(qrCode.BindingViolations)
+	s.states.untrack(qrCode.ID)
+}
+
+func (s *InMemoryQRCodeStorage) CheckHealth(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return nil
+}
+
+func (s *InMemoryQRCodeStorage) CheckCleanup() error {
+	return s.cleanup.check()
+}
+
+// Close stops the background cleanup loop.
+func (s *InMemoryQRCodeStorage) Close() error {
+	s.cleanup.stop()
+	return nil
 }
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rotate-token-demo/internal/clock/clocktest"
+	"rotate-token-demo/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestQRCodeStorage(t testing.TB) (*InMemoryQRCodeStorage, *clocktest.Fake) {
+	t.Helper()
+
+	clk := clocktest.NewFake(testNow)
+	s := NewInMemoryQRCodeStorage(clk)
+	t.Cleanup(func() { s.Close() })
+	return s, clk
+}
+
+func TestConsumeQRCodeConcurrentScansRedeemOnce(t *testing.T) {
+	s, _ := newTestQRCodeStorage(t)
+	ctx := context.Background()
+
+	qrCode := &models.QRCode{
+		ID:        "qr-1",
+		Type:      models.QRCodeTypeLogin,
+		Data:      "payload-1",
+		UserID:    "user-1",
+		CreatedAt: testNow,
+		ExpiresAt: testNow.Add(time.Minute),
+	}
+	if err := s.CreateQRCode(ctx, qrCode); err != nil {
+		t.Fatal(err)
+	}
+
+	const scanners = 64
+	var (
+		wg        sync.WaitGroup
+		start     = make(chan struct{})
+		successes = make(chan *models.QRCode, scanners)
+		failures  = make(chan error, scanners)
+	)
+	for i := 0; i < scanners; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			used, err := s.ConsumeQRCode(ctx, qrCode.Data, "192.0.2.1")
+			if err != nil {
+				failures <- err
+				return
+			}
+			successes <- used
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(successes)
+	close(failures)
+
+	if len(successes) != 1 {
+		t.Fatalf("got %d successful redemptions, want 1", len(successes))
+	}
+	for err := range failures {
+		if err != ErrQRCodeUsed {
+			t.Errorf("losing scan: got %v, want %v", err, ErrQRCodeUsed)
+		}
+	}
+	if used := <-successes; !used.IsUsed || used.UsedAt == nil {
+		t.Errorf("winning scan returned %+v, want a used code", used)
+	}
+}
+
+func TestReturnedQRCodeIsACopy(t *testing.T) {
+	s, _ := newTestQRCodeStorage(t)
+	ctx := context.Background()
+
+	created := &models.QRCode{
+		ID:        "qr-1",
+		Type:      models.QRCodeTypeLogin,
+		Data:      "payload-1",
+		UserID:    "user-1",
+		UserCode:  "BCDFGHJK",
+		ExpiresAt: testNow.Add(time.Minute),
+		Binding:   &models.QRBindingPolicy{AllowedIPs: []string{"192.0.2.0/24"}},
+	}
+	if err := s.CreateQRCode(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+	// The caller's record is copied on the way in as well.
+	created.Status = models.QRCodeStatusApproved
+
+	got, err := s.GetQRCode(ctx, "qr-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.IsUsed = true
+	got.UserID = "user-2"
+	got.UserCode = "ZZZZZZZZ"
+	got.Binding.AllowedIPs[0] = "0.0.0.0/0"
+	got.BindingViolations = append(got.BindingViolations, models.QRBindingViolation{Reason: "edited"})
+
+	stored, err := s.GetQRCode(ctx, "qr-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.IsUsed || stored.UserID != "user-1" || stored.Status != "" {
+		t.Errorf("stored code changed through a returned copy: %+v", stored)
+	}
+	if stored.Binding.AllowedIPs[0] != "192.0.2.0/24" {
+		t.Errorf("binding changed through a returned copy: %v", stored.Binding.AllowedIPs)
+	}
+	if len(stored.BindingViolations) != 0 {
+		t.Errorf("binding violations changed through a returned copy: %v", stored.BindingViolations)
+	}
+	if _, err := s.GetQRCodeByUserCode(ctx, "BCDFGHJK"); err != nil {
+		t.Errorf("user code index changed through a returned copy: %v", err)
+	}
+	if codes, _ := s.GetUserQRCodes(ctx, "user-2"); len(codes) != 0 {
+		t.Errorf("user index changed through a returned copy: %d codes for user-2", len(codes))
+	}
+}
+
+func TestCreateQRCodeRejectsTakenUserCode(t *testing.T) {
+	s, _ := newTestQRCodeStorage(t)
+	ctx := context.Background()
+
+	const creators = 32
+	var (
+		wg      sync.WaitGroup
+		start   = make(chan struct{})
+		created = make(chan string, creators)
+	)
+	for i := 0; i < creators; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			id := fmt.Sprintf("qr-%d", i)
+			err := s.CreateQRCode(ctx, &models.QRCode{
+				ID:        id,
+				Type:      models.QRCodeTypeDevice,
+				Data:      fmt.Sprintf("payload-%d", i),
+				UserCode:  "BCDFGHJK",
+				ExpiresAt: testNow.Add(time.Minute),
+			})
+			switch err {
+			case nil:
+				created <- id
+			case ErrUserCodeTaken:
+			default:
+				t.Error(err)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(created)
+
+	if len(created) != 1 {
+		t.Fatalf("got %d codes created with the same user code, want 1", len(created))
+	}
+	winner := <-created
+	got, err := s.GetQRCodeByUserCode(ctx, "BCDFGHJK")
+	if err != nil || got.ID != winner {
+		t.Errorf("user code lookup: got %v, %v, want %s", got, err, winner)
+	}
+}
+
+func TestQRCodeExpiresWithClock(t *testing.T) {
+	s, clk := newTestQRCodeStorage(t)
+	ctx := context.Background()
+
+	for _, qrCode := range []*models.QRCode{
+		{ID: "qr-1", Type: models.QRCodeTypeLogin, Data: "payload-1", ExpiresAt: testNow.Add(time.Minute)},
+		{ID: "qr-2", Type: models.QRCodeTypeLogin, Data: "payload-2", ExpiresAt: testNow.Add(time.Hour)},
+	} {
+		if err := s.CreateQRCode(ctx, qrCode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clk.Advance(2 * time.Minute)
+
+	if _, err := s.ConsumeQRCode(ctx, "payload-1", "192.0.2.1"); err != ErrQRCodeExpired {
+		t.Errorf("consume after expiry: got %v, want %v", err, ErrQRCodeExpired)
+	}
+	if active, _ := s.GetActiveQRCodes(ctx); len(active) != 1 || active[0].ID != "qr-2" {
+		t.Errorf("active codes after expiry: got %d, want only qr-2", len(active))
+	}
+
+	if err := s.CleanupExpiredQRCodes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetQRCode(ctx, "qr-1"); err != ErrQRCodeNotFound {
+		t.Errorf("expired code after cleanup: got %v, want %v", err, ErrQRCodeNotFound)
+	}
+	if _, err := s.GetQRCodeByData(ctx, "payload-1"); err != ErrQRCodeNotFound {
+		t.Errorf("expired payload after cleanup: got %v, want %v", err, ErrQRCodeNotFound)
+	}
+	if _, err := s.GetQRCode(ctx, "qr-2"); err != nil {
+		t.Errorf("live code after cleanup: %v", err)
+	}
+}
+
+func TestCheckCleanupReportsStalledLoop(t *testing.T) {
+	s, clk := newTestQRCodeStorage(t)
+
+	if err := s.CheckCleanup(); err != nil {
+		t.Fatalf("fresh loop: %v", err)
+	}
+
+	// The ticker runs on wall time, so no run happens while the fake clock jumps.
+	clk.Advance(3 * time.Minute)
+	if err := s.CheckCleanup(); !errors.Is(err, ErrCleanupStalled) {
+		t.Errorf("after missed runs: got %v, want %v", err, ErrCleanupStalled)
+	}
+
+	s.Close()
+	if err := s.CheckCleanup(); err != ErrCleanupNotRunning {
+		t.Errorf("after close: got %v, want %v", err, ErrCleanupNotRunning)
+	}
+}
+
+const (
+	benchmarkCodes = 100000
+	benchmarkUsers = 1000
+)
+
+// newBenchmarkQRCodeStorage fills a store with benchmarkCodes codes spread
+// evenly over benchmarkUsers users.
+func newBenchmarkQRCodeStorage(b *testing.B) *InMemoryQRCodeStorage {
+	b.Helper()
+
+	s, _ := newTestQRCodeStorage(b)
+	ctx := context.Background()
+	for i := 0; i < benchmarkCodes; i++ {
+		if err := s.CreateQRCode(ctx, &models.QRCode{
+			ID:        fmt.Sprintf("qr-%d", i),
+			Type:      models.QRCodeTypeLogin,
+			Data:      fmt.Sprintf("payload-%d", i),
+			UserCode:  fmt.Sprintf("CODE%06d", i),
+			UserID:    fmt.Sprintf("user-%d", i%benchmarkUsers),
+			CreatedAt: testNow,
+			ExpiresAt: testNow.Add(time.Hour),
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	return s
+}
+
+func BenchmarkGetQRCodeByData(b *testing.B) {
+	s := newBenchmarkQRCodeStorage(b)
+	ctx := context.Background()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := s.GetQRCodeByData(ctx, fmt.Sprintf("payload-%d", i%benchmarkCodes)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetQRCodeByUserCode(b *testing.B) {
+	s := newBenchmarkQRCodeStorage(b)
+	ctx := context.Background()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := s.GetQRCodeByUserCode(ctx, fmt.Sprintf("CODE%06d", i%benchmarkCodes)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListByUser(b *testing.B) {
+	s := newBenchmarkQRCodeStorage(b)
+	ctx := context.Background()
+
+	for i := 0; i < b.N; i++ {
+		codes, err := s.GetUserQRCodes(ctx, fmt.Sprintf("user-%d", i%benchmarkUsers))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(codes) != benchmarkCodes/benchmarkUsers {
+			b.Fatalf("got %d codes, want %d", len(codes), benchmarkCodes/benchmarkUsers)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"container/heap"
+	"time"
+)
+
+// TokenStats and QRCodeStats are the per-state record counts a storage keeps up
+// to date as it is mutated, so that reporting them does not walk every record.
+type TokenStats struct {
+	Active  int
+	Revoked int
+	Expired int
+}
+
+type QRCodeStats struct {
+	Active            int
+	Used              int
+	Expired           int
+	BindingViolations int
+}
+
+// stateCounter counts records by state. Explicit transitions (revoke, consume,
+// delete) are applied by the owning storage through track and untrack; records
+// whose state changes only because time passes are returned by due once their
+// expiry is reached, so the storage can re-classify them. Each record has at
+// most one pending expiry, so the queue never outgrows the records tracked. It
+// is not safe for concurrent use; the owning storage's lock guards it.
+type stateCounter struct {
+	records  map[string]*trackedRecord
+	counts   map[string]int
+	expiries expiryHeap
+}
+
+// trackedRecord is a record's state and expiry. index is its position in the
+// expiry heap, or -1 once its expiry has been returned by due.
+type trackedRecord struct {
+	key       string
+	state     string
+	expiresAt time.Time
+	index     int
+}
+
+func newStateCounter() *stateCounter {
+	return &stateCounter{
+		records: make(map[string]*trackedRecord),
+		counts:  make(map[string]int),
+	}
+}
+
+// track records key as being in state. The key is queued for re-classification
+// whenever it is new or its expiry moved.
+func (c *stateCounter) track(key, state string, expiresAt time.Time) {
+	c.counts[state]++
+
+	record, exists := c.records[key]
+	if !exists {
+		record = &trackedRecord{key: key, state: state, expiresAt: expiresAt}
+		c.records[key] = record
+		heap.Push(&c.expiries, record)
+		return
+	}
+
+	c.counts[record.state]--
+	record.state = state
+	if record.expiresAt.Equal(expiresAt) {
+		return
+	}
+	record.expiresAt = expiresAt
+	if record.index >= 0 {
+		heap.Fix(&c.expiries, record.index)
+	} else {
+		heap.Push(&c.expiries, record)
+	}
+}
+
+// untrack forgets key, including its pending expiry.
+func (c *stateCounter) untrack(key string) {
+	record, exists := c.records[key]
+	if !exists {
+		return
+	}
+	c.counts[record.state]--
+	if record.index >= 0 {
+		heap.Remove(&c.expiries, record.index)
+	}
+	delete(c.records, key)
+}
+
+// due removes and returns the keys whose expiry has passed by now.
+func (c *stateCounter) due(now time.Time) []string {
+	var keys []string
+	for c.expiries.Len() > 0 && now.After(c.expiries[0].expiresAt) {
+		record := heap.Pop(&c.expiries).(*trackedRecord)
+		keys = append(keys, record.key)
+	}
+	return keys
+}
+
+func (c *stateCounter) count(state string) int {
+	return c.counts[state]
+}
+
+type expiryHeap []*trackedRecord
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	record := x.(*trackedRecord)
+	record.index = len(*h)
+	*h = append(*h, record)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	record := old[len(old)-1]
+	old[len(old)-1] = nil
+	record.index = -1
+	*h = old[:len(old)-1]
+	return record
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"rotate-token-demo/internal/models"
+	"testing"
+	"time"
+)
+
+func TestStateCounterQueueStaysBounded(t *testing.T) {
+	c := newStateCounter()
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i%10)
+		c.track(key, "active", testNow.Add(time.Duration(i)*time.Second))
+		if i%3 == 0 {
+			c.untrack(key)
+		}
+	}
+	if got, want := c.expiries.Len(), len(c.records); got != want {
+		t.Fatalf("queued expiries: got %d, want one per record (%d)", got, want)
+	}
+
+	due := c.due(testNow.Add(time.Hour))
+	if len(due) != len(c.records) {
+		t.Errorf("due: got %d keys, want %d", len(due), len(c.records))
+	}
+	if c.expiries.Len() != 0 {
+		t.Errorf("queued expiries after due: got %d, want 0", c.expiries.Len())
+	}
+
+	// A key whose expiry was returned is queued again only if the expiry moves.
+	c.track("key-1", "expired", c.records["key-1"].expiresAt)
+	if c.expiries.Len() != 0 {
+		t.Errorf("re-classified key was queued again")
+	}
+	c.track("key-1", "active", testNow.Add(2*time.Hour))
+	if due := c.due(testNow.Add(3 * time.Hour)); len(due) != 1 || due[0] != "key-1" {
+		t.Errorf("due after extending expiry: got %v, want [key-1]", due)
+	}
+}
+
+func TestQRCodeCleanupDrainsExpiryQueue(t *testing.T) {
+	s, clk := newTestQRCodeStorage(t)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		if err := s.CreateQRCode(ctx, &models.QRCode{
+			ID:        fmt.Sprintf("qr-%d", i),
+			Type:      models.QRCodeTypeLogin,
+			Data:      fmt.Sprintf("payload-%d", i),
+			ExpiresAt: testNow.Add(time.Minute),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clk.Advance(2 * time.Minute)
+	if err := s.CleanupExpiredQRCodes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.states.expiries.Len(); n != 0 {
+		t.Errorf("queued expiries after cleanup without Stats: got %d, want 0", n)
+	}
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"rotate-token-demo/internal/clock"
 	"rotate-token-demo/internal/models"
 	"sync"
 	"time"
@@ -14,40 +16,54 @@ var (
 )
 
 type TokenStorage interface {
-	StoreRefreshToken(token *models.RefreshToken) error
-	GetRefreshToken(tokenString string) (*models.RefreshToken, error)
-	RevokeRefreshToken(tokenString string) error
-	RevokeAllUserTokens(userID string) error
-	RevokeTokenFamily(tokenFamily string) error
-	CleanupExpiredTokens() error
-	GetUserTokens(userID string) ([]*models.RefreshToken, error)
-	GetAllTokens() ([]*models.RefreshToken, error)
+	StoreRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenString string) (*models.RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, tokenString string) error
+	RevokeAllUserTokens(ctx context.Context, userID string) error
+	RevokeTokenFamily(ctx context.Context, tokenFamily string) error
+	CleanupExpiredTokens(ctx context.Context) error
+	GetUserTokens(ctx context.Context, userID string) ([]*models.RefreshToken, error)
+	GetAllTokens(ctx context.Context) ([]*models.RefreshToken, error)
+	Stats() (TokenStats, error)
 }
 
+const (
+	tokenStateActive  = "active"
+	tokenStateRevoked = "revoked"
+	tokenStateExpired = "expired"
+)
+
 type InMemoryTokenStorage struct {
-	tokens map[string]*models.RefreshToken
-	mu     sync.RWMutex
+	tokens  map[string]*models.RefreshToken
+	states  *stateCounter
+	cleanup *cleanupLoop
+	clock   clock.Clock
+	mu      sync.RWMutex
 }
 
-func NewInMemoryTokenStorage() *InMemoryTokenStorage {
+func NewInMemoryTokenStorage(clk clock.Clock) *InMemoryTokenStorage {
 	storage := &InMemoryTokenStorage{
-		tokens: make(map[string]*models.RefreshToken),
+		tokens:  make(map[string]*models.RefreshToken),
+		states:  newStateCounter(),
+		cleanup: newCleanupLoop(1*time.Hour, clk),
+		clock:   clk,
 	}
 
-	go storage.periodicCleanup()
+	storage.cleanup.start(storage.CleanupExpiredTokens)
 
 	return storage
 }
 
-func (s *InMemoryTokenStorage) StoreRefreshToken(token *models.RefreshToken) error {
+func (s *InMemoryTokenStorage) StoreRefreshToken(ctx context.Context, token *models.RefreshToken) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.tokens[token.Token] = token
+	s.tokens[string(token.Token)] = token
+	s.track(token, s.clock.Now())
 	return nil
 }
 
-func (s *InMemoryTokenStorage) GetRefreshToken(tokenString string) (*models.RefreshToken, error) {
+func (s *InMemoryTokenStorage) GetRefreshToken(ctx context.Context, tokenString string) (*models.RefreshToken, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -60,14 +76,14 @@ func (s *InMemoryTokenStorage) GetRefreshToken(tokenString string) (*models.Refr
 		return nil, ErrTokenRevoked
 	}
 
-	if time.Now().After(token.ExpiresAt) {
+	if s.clock.Now().After(token.ExpiresAt) {
 		return nil, ErrTokenExpired
 	}
 
 	return token, nil
 }
 
-func (s *InMemoryTokenStorage) RevokeRefreshToken(tokenString string) error {
+func (s *InMemoryTokenStorage) RevokeRefreshToken(ctx context.Context, tokenString string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -76,48 +92,51 @@ func (s *InMemoryTokenStorage) RevokeRefreshToken(tokenString string) error {
 		return ErrTokenNotFound
 	}
 
-	token.IsRevoked = true
+	s.revoke(token, s.clock.Now())
 	return nil
 }
 
-func (s *InMemoryTokenStorage) RevokeAllUserTokens(userID string) error {
+func (s *InMemoryTokenStorage) RevokeAllUserTokens(ctx context.Context, userID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	now := s.clock.Now()
 	for _, token := range s.tokens {
 		if token.UserID == userID {
-			token.IsRevoked = true
+			s.revoke(token, now)
 		}
 	}
 	return nil
 }
 
-func (s *InMemoryTokenStorage) RevokeTokenFamily(tokenFamily string) error {
+func (s *InMemoryTokenStorage) RevokeTokenFamily(ctx context.Context, tokenFamily string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	now := s.clock.Now()
 	for _, token := range s.tokens {
 		if token.TokenFamily == tokenFamily {
-			token.IsRevoked = true
+			s.revoke(token, now)
 		}
 	}
 	return nil
 }
 
-func (s *InMemoryTokenStorage) CleanupExpiredTokens() error {
+func (s *InMemoryTokenStorage) CleanupExpiredTokens(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	now := time.Now()
+	now := s.clock.Now()
 	for tokenString, token := range s.tokens {
 		if now.After(token.ExpiresAt) {
 			delete(s.tokens, tokenString)
+			s.states.untrack(tokenString)
 		}
 	}
 	return nil
 }
 
-func (s *InMemoryTokenStorage) GetUserTokens(userID string) ([]*models.RefreshToken, error) {
+func (s *InMemoryTokenStorage) GetUserTokens(ctx context.Context, userID string) ([]*models.RefreshToken, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -130,16 +149,23 @@ func (s *InMemoryTokenStorage) GetUserTokens(userID string) ([]*models.RefreshTo
 	return tokens, nil
 }
 
-func (s *InMemoryTokenStorage) periodicCleanup() {
-	ticker := time.NewTicker(1 * time.Hour)
-	defer ticker.Stop()
+func (s *InMemoryTokenStorage) CheckHealth(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return nil
+}
 
-	for range ticker.C {
-		s.CleanupExpiredTokens()
-	}
+func (s *InMemoryTokenStorage) CheckCleanup() error {
+	return s.cleanup.check()
+}
+
+// Close stops the background cleanup loop.
+func (s *InMemoryTokenStorage) Close() error {
+	s.cleanup.stop()
+	return nil
 }
 
-func (s *InMemoryTokenStorage) GetAllTokens() ([]*models.RefreshToken, error) {
+func (s *InMemoryTokenStorage) GetAllTokens(ctx context.Context) ([]*models.RefreshToken, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -149,3 +175,37 @@ func (s *InMemoryTokenStorage) GetAllTokens() ([]*models.RefreshToken, error) {
 	}
 	return tokens, nil
 }
+
+// Stats reports how many stored tokens are active, revoked or expired without
+// scanning them; tokens that expired since the last call are re-classified here.
+func (s *InMemoryTokenStorage) Stats() (TokenStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	for _, tokenString := range s.states.due(now) {
+		s.track(s.tokens[tokenString], now)
+	}
+
+	return TokenStats{
+		Active:  s.states.count(tokenStateActive),
+		Revoked: s.states.count(tokenStateRevoked),
+		Expired: s.states.count(tokenStateExpired),
+	}, nil
+}
+
+// revoke and track must be called with mu held for writing.
+func (s *InMemoryTokenStorage) revoke(token *models.RefreshToken, now time.Time) {
+	token.IsRevoked = true
+	s.track(token, now)
+}
+
+func (s *InMemoryTokenStorage) track(token *models.RefreshToken, now time.Time) {
+	state := tokenStateActive
+	if token.IsRevoked {
+		state = tokenStateRevoked
+	} else if now.After(token.ExpiresAt) {
+		state = tokenStateExpired
+	}
+	s.states.track(string(token.Token), state, token.ExpiresAt)
+}
@@ -1,10 +1,11 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"rotate-token-demo/internal/clock"
 	"rotate-token-demo/internal/models"
 	"sync"
-	"time"
 )
 
 var (
@@ -13,28 +14,36 @@ var (
 )
 
 type UserStorage interface {
-	CreateUser(user *models.User) error
-	GetUserByID(id string) (*models.User, error)
-	GetUserByUsername(username string) (*models.User, error)
-	GetUserByEmail(email string) (*models.User, error)
-	UpdateUser(user *models.User) error
-	UpdateLastLogin(userID string) error
-	DeleteUser(id string) error
-	ListUsers() ([]*models.User, error)
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	UpdateUser(ctx context.Context, user *models.User) error
+	UpdateLastLogin(ctx context.Context, userID string) error
+	DeleteUser(ctx context.Context, id string) error
+	ListUsers(ctx context.Context) ([]*models.User, error)
 }
 
 type InMemoryUserStorage struct {
 	users map[string]*models.User
+	clock clock.Clock
 	mu    sync.RWMutex
 }
 
-func NewInMemoryUserStorage() *InMemoryUserStorage {
+func NewInMemoryUserStorage(clk clock.Clock) *InMemoryUserStorage {
 	return &InMemoryUserStorage{
 		users: make(map[string]*models.User),
+		clock: clk,
 	}
 }
 
-func (s *InMemoryUserStorage) CreateUser(user *models.User) error {
+func (s *InMemoryUserStorage) CheckHealth(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return nil
+}
+
+func (s *InMemoryUserStorage) CreateUser(ctx context.Context, user *models.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	for _, existingUser := range s.users {
@@ -47,7 +56,7 @@ func (s *InMemoryUserStorage) CreateUser(user *models.User) error {
 	return nil
 }
 
-func (s *InMemoryUserStorage) GetUserByID(id string) (*models.User, error) {
+func (s *InMemoryUserStorage) GetUserByID(ctx context.Context, id string) (*models.User, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -58,7 +67,7 @@ func (s *InMemoryUserStorage) GetUserByID(id string) (*models.User, error) {
 	return user, nil
 }
 
-func (s *InMemoryUserStorage) GetUserByUsername(username string) (*models.User, error) {
+func (s *InMemoryUserStorage) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -70,7 +79,7 @@ func (s *InMemoryUserStorage) GetUserByUsername(username string) (*models.User,
 	return nil, ErrUserNotFound
 }
 
-func (s *InMemoryUserStorage) GetUserByEmail(email string) (*models.User, error) {
+func (s *InMemoryUserStorage) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -82,7 +91,7 @@ func (s *InMemoryUserStorage) GetUserByEmail(email string) (*models.User, error)
 	return nil, ErrUserNotFound
 }
 
-func (s *InMemoryUserStorage) UpdateUser(user *models.User) error {
+func (s *InMemoryUserStorage) UpdateUser(ctx context.Context, user *models.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -94,7 +103,7 @@ func (s *InMemoryUserStorage) UpdateUser(user *models.User) error {
 	return nil
 }
 
-func (s *InMemoryUserStorage) UpdateLastLogin(userID string) error {
+func (s *InMemoryUserStorage) UpdateLastLogin(ctx context.Context, userID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -103,11 +112,11 @@ func (s *InMemoryUserStorage) UpdateLastLogin(userID string) error {
 		return ErrUserNotFound
 	}
 
-	user.LastLogin = time.Now()
+	user.LastLogin = s.clock.Now()
 	return nil
 }
 
-func (s *InMemoryUserStorage) DeleteUser(id string) error {
+func (s *InMemoryUserStorage) DeleteUser(ctx context.Context, id string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -119,7 +128,7 @@ func (s *InMemoryUserStorage) DeleteUser(id string) error {
 	return nil
 }
 
-func (s *InMemoryUserStorage) ListUsers() ([]*models.User, error) {
+func (s *InMemoryUserStorage) ListUsers(ctx context.Context) ([]*models.User, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
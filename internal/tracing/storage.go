@@ -0,0 +1,283 @@
+package tracing
+
+import (
+	"context"
+	"rotate-token-demo/internal/models"
+	"rotate-token-demo/internal/storage"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// The wrappers below add a span around every storage call. Attributes are
+// limited to record IDs; refresh tokens, QR payloads, user codes and proof IDs
+// are credentials or close enough to them that they never leave the process.
+
+func userID(id string) attribute.KeyValue { return attribute.String("enduser.id", id) }
+func qrID(id string) attribute.KeyValue   { return attribute.String("qr.id", id) }
+
+type userStorage struct{ next storage.UserStorage }
+
+func UserStorage(next storage.UserStorage) storage.UserStorage { return &userStorage{next} }
+
+func (s *userStorage) CreateUser(ctx context.Context, user *models.User) (err error) {
+	ctx, span := Start(ctx, "UserStorage.CreateUser", userID(user.ID))
+	defer func() { End(span, err) }()
+	return s.next.CreateUser(ctx, user)
+}
+
+func (s *userStorage) GetUserByID(ctx context.Context, id string) (_ *models.User, err error) {
+	ctx, span := Start(ctx, "UserStorage.GetUserByID", userID(id))
+	defer func() { End(span, err) }()
+	return s.next.GetUserByID(ctx, id)
+}
+
+func (s *userStorage) GetUserByUsername(ctx context.Context, username string) (_ *models.User, err error) {
+	ctx, span := Start(ctx, "UserStorage.GetUserByUsername")
+	defer func() { End(span, err) }()
+	return s.next.GetUserByUsername(ctx, username)
+}
+
+func (s *userStorage) GetUserByEmail(ctx context.Context, email string) (_ *models.User, err error) {
+	ctx, span := Start(ctx, "UserStorage.GetUserByEmail")
+	defer func() { End(span, err) }()
+	return s.next.GetUserByEmail(ctx, email)
+}
+
+func (s *userStorage) UpdateUser(ctx context.Context, user *models.User) (err error) {
+	ctx, span := Start(ctx, "UserStorage.UpdateUser", userID(user.ID))
+	defer func() { End(span, err) }()
+	return s.next.UpdateUser(ctx, user)
+}
+
+func (s *userStorage) UpdateLastLogin(ctx context.Context, id string) (err error) {
+	ctx, span := Start(ctx, "UserStorage.UpdateLastLogin", userID(id))
+	defer func() { End(span, err) }()
+	return s.next.UpdateLastLogin(ctx, id)
+}
+
+func (s *userStorage) DeleteUser(ctx context.Context, id string) (err error) {
+	ctx, span := Start(ctx, "UserStorage.DeleteUser", userID(id))
+	defer func() { End(span, err) }()
+	return s.next.DeleteUser(ctx, id)
+}
+
+func (s *userStorage) ListUsers(ctx context.Context) (_ []*models.User, err error) {
+	ctx, span := Start(ctx, "UserStorage.ListUsers")
+	defer func() { End(span, err) }()
+	return s.next.ListUsers(ctx)
+}
+
+type tokenStorage struct{ next storage.TokenStorage }
+
+func TokenStorage(next storage.TokenStorage) storage.TokenStorage { return &tokenStorage{next} }
+
+func (s *tokenStorage) StoreRefreshToken(ctx context.Context, token *models.RefreshToken) (err error) {
+	ctx, span := Start(ctx, "TokenStorage.StoreRefreshToken", userID(token.UserID))
+	defer func() { End(span, err) }()
+	return s.next.StoreRefreshToken(ctx, token)
+}
+
+func (s *tokenStorage) GetRefreshToken(ctx context.Context, tokenString string) (_ *models.RefreshToken, err error) {
+	ctx, span := Start(ctx, "TokenStorage.GetRefreshToken")
+	defer func() { End(span, err) }()
+	return s.next.GetRefreshToken(ctx, tokenString)
+}
+
+func (s *tokenStorage) RevokeRefreshToken(ctx context.Context, tokenString string) (err error) {
+	ctx, span := Start(ctx, "TokenStorage.RevokeRefreshToken")
+	defer func() { End(span, err) }()
+	return s.next.RevokeRefreshToken(ctx, tokenString)
+}
+
+func (s *tokenStorage) RevokeAllUserTokens(ctx context.Context, id string) (err error) {
+	ctx, span := Start(ctx, "TokenStorage.RevokeAllUserTokens", userID(id))
+	defer func() { End(span, err) }()
+	return s.next.RevokeAllUserTokens(ctx, id)
+}
+
+func (s *tokenStorage) RevokeTokenFamily(ctx context.Context, tokenFamily string) (err error) {
+	ctx, span := Start(ctx, "TokenStorage.RevokeTokenFamily", attribute.String("token.family", tokenFamily))
+	defer func() { End(span, err) }()
+	return s.next.RevokeTokenFamily(ctx, tokenFamily)
+}
+
+func (s *tokenStorage) CleanupExpiredTokens(ctx context.Context) (err error) {
+	ctx, span := Start(ctx, "TokenStorage.CleanupExpiredTokens")
+	defer func() { End(span, err) }()
+	return s.next.CleanupExpiredTokens(ctx)
+}
+
+func (s *tokenStorage) GetUserTokens(ctx context.Context, id string) (_ []*models.RefreshToken, err error) {
+	ctx, span := Start(ctx, "TokenStorage.GetUserTokens", userID(id))
+	defer func() { End(span, err) }()
+	return s.next.GetUserTokens(ctx, id)
+}
+
+func (s *tokenStorage) GetAllTokens(ctx context.Context) (_ []*models.RefreshToken, err error) {
+	ctx, span := Start(ctx, "TokenStorage.GetAllTokens")
+	defer func() { End(span, err) }()
+	return s.next.GetAllTokens(ctx)
+}
+
+func (s *tokenStorage) Stats() (storage.TokenStats, error) {
+	return s.next.Stats()
+}
+
+type qrCodeStorage struct{ next storage.QRCodeStorage }
+
+func QRCodeStorage(next storage.QRCodeStorage) storage.QRCodeStorage { return &qrCodeStorage{next} }
+
+func (s *qrCodeStorage) CreateQRCode(ctx context.Context, qrCode *models.QRCode) (err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.CreateQRCode", qrID(qrCode.ID))
+	defer func() { End(span, err) }()
+	return s.next.CreateQRCode(ctx, qrCode)
+}
+
+func (s *qrCodeStorage) GetQRCode(ctx context.Context, id string) (_ *models.QRCode, err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.GetQRCode", qrID(id))
+	defer func() { End(span, err) }()
+	return s.next.GetQRCode(ctx, id)
+}
+
+func (s *qrCodeStorage) GetQRCodeByData(ctx context.Context, data string) (_ *models.QRCode, err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.GetQRCodeByData")
+	defer func() { End(span, err) }()
+	return s.next.GetQRCodeByData(ctx, data)
+}
+
+func (s *qrCodeStorage) GetQRCodeByUserCode(ctx context.Context, userCode string) (_ *models.QRCode, err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.GetQRCodeByUserCode")
+	defer func() { End(span, err) }()
+	return s.next.GetQRCodeByUserCode(ctx, userCode)
+}
+
+func (s *qrCodeStorage) TransitionQRCode(ctx context.Context, id string, transition storage.QRCodeTransition) (_ *models.QRCode, err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.TransitionQRCode", qrID(id))
+	defer func() { End(span, err) }()
+	return s.next.TransitionQRCode(ctx, id, transition)
+}
+
+func (s *qrCodeStorage) RecordQRCodePoll(ctx context.Context, id string, backoff int) (_ *models.QRCode, _ bool, err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.RecordQRCodePoll", qrID(id))
+	defer func() { End(span, err) }()
+	return s.next.RecordQRCodePoll(ctx, id, backoff)
+}
+
+func (s *qrCodeStorage) MarkQRCodeAsUsed(ctx context.Context, id string, ipAddress string) (err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.MarkQRCodeAsUsed", qrID(id))
+	defer func() { End(span, err) }()
+	return s.next.MarkQRCodeAsUsed(ctx, id, ipAddress)
+}
+
+func (s *qrCodeStorage) ConsumeQRCode(ctx context.Context, data string, ipAddress string) (_ *models.QRCode, err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.ConsumeQRCode")
+	defer func() { End(span, err) }()
+	return s.next.ConsumeQRCode(ctx, data, ipAddress)
+}
+
+func (s *qrCodeStorage) RecordBindingViolation(ctx context.Context, id string, violation models.QRBindingViolation) (err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.RecordBindingViolation", qrID(id))
+	defer func() { End(span, err) }()
+	return s.next.RecordBindingViolation(ctx, id, violation)
+}
+
+func (s *qrCodeStorage) GetUserQRCodes(ctx context.Context, id string) (_ []*models.QRCode, err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.GetUserQRCodes", userID(id))
+	defer func() { End(span, err) }()
+	return s.next.GetUserQRCodes(ctx, id)
+}
+
+func (s *qrCodeStorage) GetAllQRCodes(ctx context.Context) (_ []*models.QRCode, err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.GetAllQRCodes")
+	defer func() { End(span, err) }()
+	return s.next.GetAllQRCodes(ctx)
+}
+
+func (s *qrCodeStorage) GetActiveQRCodes(ctx context.Context) (_ []*models.QRCode, err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.GetActiveQRCodes")
+	defer func() { End(span, err) }()
+	return s.next.GetActiveQRCodes(ctx)
+}
+
+func (s *qrCodeStorage) CleanupExpiredQRCodes(ctx context.Context) (err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.CleanupExpiredQRCodes")
+	defer func() { End(span, err) }()
+	return s.next.CleanupExpiredQRCodes(ctx)
+}
+
+func (s *qrCodeStorage) DeleteQRCode(ctx context.Context, id string) (err error) {
+	ctx, span := Start(ctx, "QRCodeStorage.DeleteQRCode", qrID(id))
+	defer func() { End(span, err) }()
+	return s.next.DeleteQRCode(ctx, id)
+}
+
+func (s *qrCodeStorage) Subscribe(id string) (<-chan models.QRCodeEvent, func()) {
+	return s.next.Subscribe(id)
+}
+
+func (s *qrCodeStorage) Stats() (storage.QRCodeStats, error) {
+	return s.next.Stats()
+}
+
+type dpopReplayStorage struct{ next storage.DPoPReplayStorage }
+
+func DPoPReplayStorage(next storage.DPoPReplayStorage) storage.DPoPReplayStorage {
+	return &dpopReplayStorage{next}
+}
+
+func (s *dpopReplayStorage) MarkProofUsed(ctx context.Context, jti string, expiresAt time.Time) (err error) {
+	ctx, span := Start(ctx, "DPoPReplayStorage.MarkProofUsed")
+	defer func() { End(span, err) }()
+	return s.next.MarkProofUsed(ctx, jti, expiresAt)
+}
+
+func (s *dpopReplayStorage) CleanupExpiredProofs(ctx context.Context) (err error) {
+	ctx, span := Start(ctx, "DPoPReplayStorage.CleanupExpiredProofs")
+	defer func() { End(span, err) }()
+	return s.next.CleanupExpiredProofs(ctx)
+}
+
+type attemptStorage struct{ next storage.AttemptStorage }
+
+func AttemptStorage(next storage.AttemptStorage) storage.AttemptStorage { return &attemptStorage{next} }
+
+func (s *attemptStorage) RegisterFailure(ctx context.Context, key string, window time.Duration, maxFailures int, lockout time.Duration) (_ time.Time, err error) {
+	ctx, span := Start(ctx, "AttemptStorage.RegisterFailure")
+	defer func() { End(span, err) }()
+	return s.next.RegisterFailure(ctx, key, window, maxFailures, lockout)
+}
+
+func (s *attemptStorage) LockedUntil(ctx context.Context, key string) (_ time.Time, err error) {
+	ctx, span := Start(ctx, "AttemptStorage.LockedUntil")
+	defer func() { End(span, err) }()
+	return s.next.LockedUntil(ctx, key)
+}
+
+func (s *attemptStorage) ResetFailures(ctx context.Context, key string) (err error) {
+	ctx, span := Start(ctx, "AttemptStorage.ResetFailures")
+	defer func() { End(span, err) }()
+	return s.next.ResetFailures(ctx, key)
+}
+
+func (s *attemptStorage) CleanupExpiredAttempts(ctx context.Context) (err error) {
+	ctx, span := Start(ctx, "AttemptStorage.CleanupExpiredAttempts")
+	defer func() { End(span, err) }()
+	return s.next.CleanupExpiredAttempts(ctx)
+}
+
+type auditLog struct{ next storage.AuditLog }
+
+func AuditLog(next storage.AuditLog) storage.AuditLog { return &auditLog{next} }
+
+func (l *auditLog) Record(ctx context.Context, event *models.AuditEvent) (err error) {
+	ctx, span := Start(ctx, "AuditLog.Record", attribute.String("audit.event", event.Type))
+	defer func() { End(span, err) }()
+	return l.next.Record(ctx, event)
+}
+
+func (l *auditLog) Query(ctx context.Context, filter models.AuditFilter) (_ []*models.AuditEvent, err error) {
+	ctx, span := Start(ctx, "AuditLog.Query")
+	defer func() { End(span, err) }()
+	return l.next.Query(ctx, filter)
+}
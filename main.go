@@ -1,48 +1,249 @@
 package main
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
 	"rotate-token-demo/internal/api"
+	"rotate-token-demo/internal/clock"
 	"rotate-token-demo/internal/config"
+	"rotate-token-demo/internal/health"
+	"rotate-token-demo/internal/logging"
+	"rotate-token-demo/internal/metrics"
 	"rotate-token-demo/internal/models"
 	"rotate-token-demo/internal/service"
 	"rotate-token-demo/internal/storage"
+	"rotate-token-demo/internal/tracing"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// main starts the components in dependency order and, on SIGINT or SIGTERM,
+// stops them in reverse: the HTTP server drains first, then the storages' cleanup
+// loops stop, the audit log is closed, and buffered spans are flushed last so the
+// spans of drained requests are not lost. SIGHUP reloads the configuration.
 func main() {
-	cfg := config.New()
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(2)
+	}
+
+	logger, err := logging.New(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to set up logging:", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	liveConfig := config.NewLive(cfg, os.Args[1:])
+
+	if cfg.JWTSecret == config.DefaultJWTSecret {
+		slog.Warn("Using the built-in development JWT secret; set JWT_SECRET before exposing this server")
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	userStorage := storage.NewInMemoryUserStorage(clock.System)
+	tokenStorage := storage.NewInMemoryTokenStorage(clock.System)
+	qrStorage := storage.NewInMemoryQRCodeStorage(clock.System)
+	dpopReplayStorage := storage.NewInMemoryDPoPReplayStorage(clock.System)
+	attemptStorage := storage.NewInMemoryAttemptStorage(clock.System)
+
+	auditLog, err := newAuditLog(cfg)
+	if err != nil {
+		fatal("Failed to open audit log", err)
+	}
+
+	appMetrics := metrics.New(tokenStorage, qrStorage)
+
+	// The demo account has a published password, so it only exists outside
+	// production and never holds the admin role.
+	if cfg.Environment != config.EnvironmentProduction {
+		createDemoUser(context.Background(), userStorage)
+	}
+
+	// Services see the storages through tracing wrappers; metrics and health
+	// checks use the storages directly.
+	tracedUsers := tracing.UserStorage(userStorage)
+	tracedTokens := tracing.TokenStorage(tokenStorage)
+
+	authService := service.NewAuthService(tracedUsers, tracedTokens, tracing.DPoPReplayStorage(dpopReplayStorage),
+		tracing.AuditLog(appMetrics.InstrumentAuditLog(auditLog)), liveConfig, clock.System)
+	qrService := service.NewQRCodeService(tracing.QRCodeStorage(qrStorage), tracedUsers, tracedTokens,
+		tracing.AttemptStorage(attemptStorage), authService)
+
+	healthChecker := newHealthChecker(authService, map[string]interface{}{
+		"users":       userStorage,
+		"tokens":      tokenStorage,
+		"qr_codes":    qrStorage,
+		"dpop_replay": dpopReplayStorage,
+		"attempts":    attemptStorage,
+		"audit_log":   auditLog,
+	})
+
+	server := api.NewServer(authService, qrService, appMetrics, healthChecker, liveConfig, clock.System)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go reloadOnHangup(hup, authService)
 
-	userStorage := storage.NewInMemoryUserStorage()
-	tokenStorage := storage.NewInMemoryTokenStorage()
-	qrStorage := storage.NewInMemoryQRCodeStorage()
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server listening", "port", cfg.Port)
+		serverErr <- server.Start()
+	}()
 
-	createDemoUser(userStorage)
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			fatal("Failed to start server", err)
+		}
+	case <-ctx.Done():
+		stop()
+		slog.Info("Shutting down", "timeout", cfg.ShutdownTimeout)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to drain in-flight requests", "error", err)
+	}
+	closeAll(tokenStorage, qrStorage, dpopReplayStorage, attemptStorage, auditLog)
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+
+	slog.Info("Shutdown complete")
+}
 
-	authService := service.NewAuthService(userStorage, tokenStorage, cfg)
-	qrService := service.NewQRCodeService(qrStorage, userStorage, tokenStorage, authService)
+// reloadOnHangup reloads the configuration on every SIGHUP. An invalid
+// configuration is logged and the running one is kept.
+func reloadOnHangup(hup <-chan os.Signal, authService *service.AuthService) {
+	for range hup {
+		result, err := authService.ReloadConfig(context.Background(), "", models.ClientInfo{})
+		if err != nil {
+			slog.Error("Configuration reload failed, keeping the current configuration", "error", err)
+			continue
+		}
+		slog.Info("Configuration reloaded", "applied", result.Applied, "restart_required", result.RestartRequired)
+	}
+}
 
-	server := api.NewServer(authService, qrService, cfg)
+// closeAll closes every component that holds background goroutines or open
+// resources, in the order given.
+func closeAll(components ...interface{}) {
+	for _, component := range components {
+		if closer, ok := component.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				slog.Error("Failed to close component", "component", fmt.Sprintf("%T", component), "error", err)
+			}
+		}
+	}
+}
+
+// newHealthChecker registers the readiness checks: the signing key and every
+// storage that can report on its backend or cleanup loop.
+func newHealthChecker(authService *service.AuthService, storages map[string]interface{}) *health.Checker {
+	checker := health.NewChecker()
+	checker.Add("signing_key", authService.CheckSigningKey)
+
+	for name, s := range storages {
+		if s, ok := s.(storage.HealthChecker); ok {
+			checker.Add("storage."+name, s.CheckHealth)
+		}
+		if s, ok := s.(storage.CleanupChecker); ok {
+			checker.Add("cleanup."+name, func(context.Context) error { return s.CheckCleanup() })
+		}
+	}
+
+	return checker
+}
+
+// configCommand implements "config print [flags]", which shows the effective
+// configuration with secrets masked and reports whether it is valid.
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: rotate-token-demo config print [flags]")
+		return 2
+	}
+
+	cfg, err := config.Parse(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return 2
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to print configuration:", err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return 2
+	}
+	return 0
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
 
-	if err := server.Start(); err != nil {
-		log.Fatal("Failed to start server:", err)
+// newAuditLog opens the configured audit backend. The SQL backend needs a
+// database/sql driver for AuditLogDriver to be linked into the binary.
+func newAuditLog(cfg *config.Config) (storage.AuditLog, error) {
+	switch cfg.AuditLogBackend {
+	case "file":
+		return storage.NewJSONLinesAuditLog(cfg.AuditLogPath)
+	case "sql":
+		db, err := sql.Open(cfg.AuditLogDriver, cfg.AuditLogDSN)
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewSQLAuditLog(db, cfg.AuditLogDriver)
+	default:
+		return storage.NewInMemoryAuditLog(cfg.AuditLogCapacity), nil
 	}
 }
 
-func createDemoUser(userStorage storage.UserStorage) {
+func createDemoUser(ctx context.Context, userStorage storage.UserStorage) {
 	// Check if demo user already exists
-	if _, err := userStorage.GetUserByUsername("demo"); err == nil {
-		log.Printf("Demo user already exists")
+	if _, err := userStorage.GetUserByUsername(ctx, "demo"); err == nil {
+		slog.InfoContext(ctx, "Demo user already exists")
 		return
 	}
 
 	// Create demo user
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
 	if err != nil {
-		log.Printf("Failed to hash password for demo user: %v", err)
+		slog.ErrorContext(ctx, "Failed to hash password for demo user", "error", err)
 		return
 	}
 
@@ -54,8 +255,8 @@ func createDemoUser(userStorage storage.UserStorage) {
 		CreateAt: time.Now(),
 	}
 
-	if err := userStorage.CreateUser(demoUser); err != nil {
-		log.Printf("Failed to create demo user: %v", err)
+	if err := userStorage.CreateUser(ctx, demoUser); err != nil {
+		slog.ErrorContext(ctx, "Failed to create demo user", "error", err)
 		return
 	}
 }